@@ -0,0 +1,451 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Network wide settings are kept in the world state under composite keys of this object type
+const configObjectType = "config"
+
+const (
+	configMaxLoanTermDays = "maxLoanTermDays"
+	configDevNetwork      = "devNetwork"
+	configBlackoutDates   = "blackoutDates"
+	configCoolingOff      = "coolingOffSeconds"
+	configRegions         = "regions"
+	configMaxLoans        = "maxLoansPerLender"
+	configConcentration   = "concentrationLimit"
+	configMinCoverage     = "minCollateralCoverage"
+	configOneLoanPerPair  = "oneLoanPerBorrowerPerLender"
+	configRoundingMode    = "roundingMode"
+)
+
+// Rounding modes for interest amounts. Interest is rounded to the nearest unit unless an admin sets
+// another mode.
+const (
+	roundingFloor   = "floor"
+	roundingCeil    = "ceil"
+	roundingNearest = "nearest"
+)
+
+// roundingFuncs maps every rounding mode to the function rounding an amount to a whole unit
+var roundingFuncs = map[string]func(float64) float64{
+	roundingFloor:   math.Floor,
+	roundingCeil:    math.Ceil,
+	roundingNearest: math.Round,
+}
+
+// SetMaxLoanTermDays caps the number of days between the start and end date of newly issued assets.
+// A value of zero removes the cap. It can only be called by an admin.
+func (s *SmartContract) SetMaxLoanTermDays(ctx contractapi.TransactionContextInterface, days int) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if days < 0 {
+		return fmt.Errorf("maximum loan term must be zero or a positive integer")
+	}
+
+	log.Printf("SetMaxLoanTermDays Put: %v days", days)
+	return putConfig(ctx, configMaxLoanTermDays, days)
+}
+
+// SetDevNetwork marks the network as a dev network, which enables GenerateTestAssets.
+// It can only be called by an admin.
+func (s *SmartContract) SetDevNetwork(ctx contractapi.TransactionContextInterface, enabled bool) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("SetDevNetwork Put: %v", enabled)
+	return putConfig(ctx, configDevNetwork, enabled)
+}
+
+// SetBlackoutDates replaces the list of YYYYMMDD dates on which no assets may be issued,
+// e.g. public holidays. An empty list removes all blackout dates. It can only be called by an admin.
+func (s *SmartContract) SetBlackoutDates(ctx contractapi.TransactionContextInterface, dates []int) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	for _, date := range dates {
+		_, err = parseDate(date)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("SetBlackoutDates Put: %v", dates)
+	return putConfig(ctx, configBlackoutDates, dates)
+}
+
+// verifyNotBlackoutDate rejects the transaction if its timestamp falls on a configured blackout date.
+func verifyNotBlackoutDate(ctx contractapi.TransactionContextInterface) error {
+	var dates []int
+	_, err := getConfig(ctx, configBlackoutDates, &dates)
+	if err != nil {
+		return err
+	}
+	if len(dates) == 0 {
+		return nil
+	}
+
+	today, err := txDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, date := range dates {
+		if date == today {
+			return fmt.Errorf("assets cannot be issued on blackout date %v", today)
+		}
+	}
+
+	return nil
+}
+
+// SetCoolingOffPeriod sets the number of seconds an asset has to stay PENDING before it can begin
+// trading. A value of zero removes the cooling-off period. It can only be called by an admin.
+func (s *SmartContract) SetCoolingOffPeriod(ctx contractapi.TransactionContextInterface, seconds int64) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if seconds < 0 {
+		return fmt.Errorf("cooling-off period must be zero or a positive integer")
+	}
+
+	log.Printf("SetCoolingOffPeriod Put: %v seconds", seconds)
+	return putConfig(ctx, configCoolingOff, seconds)
+}
+
+// verifyCoolingOffElapsed checks that the configured cooling-off period has passed since the asset entered PENDING.
+func verifyCoolingOffElapsed(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	var seconds int64
+	_, err := getConfig(ctx, configCoolingOff, &seconds)
+	if err != nil {
+		return err
+	}
+	if seconds == 0 {
+		return nil
+	}
+
+	transitions, err := getStateTransitions(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+
+	entered, ok := lastEnteredState(transitions, PENDING)
+	if !ok {
+		return fmt.Errorf("history of asset %v does not record it entering state %v", asset.ID, PENDING)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if now.Sub(entered) < time.Duration(seconds)*time.Second {
+		return fmt.Errorf("asset %v is within its cooling-off period of %v seconds", asset.ID, seconds)
+	}
+
+	return nil
+}
+
+// SetRegions replaces the list of regions assets can be tagged with. An empty list allows any region.
+// It can only be called by an admin.
+func (s *SmartContract) SetRegions(ctx contractapi.TransactionContextInterface, regions []string) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	for _, region := range regions {
+		if len(region) == 0 {
+			return fmt.Errorf("regions must be non-empty strings")
+		}
+	}
+
+	log.Printf("SetRegions Put: %v", regions)
+	return putConfig(ctx, configRegions, regions)
+}
+
+// verifyRegion checks that region is in the admin managed list of regions. Any region is allowed
+// while no list has been configured.
+func verifyRegion(ctx contractapi.TransactionContextInterface, region string) error {
+	var regions []string
+	_, err := getConfig(ctx, configRegions, &regions)
+	if err != nil {
+		return err
+	}
+	if len(regions) == 0 {
+		return nil
+	}
+
+	for _, allowed := range regions {
+		if region == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("region %q is not in the list of allowed regions", region)
+}
+
+// SetMaxLoansPerLender caps the number of active loans a single lender can hold when issuing a new
+// asset. A value of zero removes the cap. It can only be called by an admin.
+func (s *SmartContract) SetMaxLoansPerLender(ctx contractapi.TransactionContextInterface, max int) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if max < 0 {
+		return fmt.Errorf("maximum loans per lender must be zero or a positive integer")
+	}
+
+	log.Printf("SetMaxLoansPerLender Put: %v", max)
+	return putConfig(ctx, configMaxLoans, max)
+}
+
+// SetOneLoanPerBorrower enables or disables the policy that a borrower can hold at most one active
+// loan from the same lender, which is enforced when a borrower is assigned. It can only be called by an admin.
+func (s *SmartContract) SetOneLoanPerBorrower(ctx contractapi.TransactionContextInterface, enabled bool) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("SetOneLoanPerBorrower Put: %v", enabled)
+	return putConfig(ctx, configOneLoanPerPair, enabled)
+}
+
+// SetConcentrationLimit sets the share of the total outstanding amount, as a fraction between 0 and 1,
+// above which a single borrower is flagged by GetConcentrationByBorrower. A value of zero disables
+// flagging. It can only be called by an admin.
+func (s *SmartContract) SetConcentrationLimit(ctx contractapi.TransactionContextInterface, limit float64) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if limit < 0 || limit > 1 {
+		return fmt.Errorf("concentration limit must be between 0 and 1")
+	}
+
+	log.Printf("SetConcentrationLimit Put: %v", limit)
+	return putConfig(ctx, configConcentration, limit)
+}
+
+// SetMinCollateralCoverage requires newly issued assets to be secured by collateral valued at least
+// coverage times the loan amount, e.g. 1.2 for 120%. A value of zero allows unsecured loans.
+// It can only be called by an admin.
+func (s *SmartContract) SetMinCollateralCoverage(ctx contractapi.TransactionContextInterface, coverage float64) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if coverage < 0 {
+		return fmt.Errorf("minimum collateral coverage must be zero or a positive number")
+	}
+
+	log.Printf("SetMinCollateralCoverage Put: %v", coverage)
+	return putConfig(ctx, configMinCoverage, coverage)
+}
+
+// verifyCollateralCoverage checks the collateral of a new asset against the configured minimum coverage.
+func verifyCollateralCoverage(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	var minCoverage float64
+	_, err := getConfig(ctx, configMinCoverage, &minCoverage)
+	if err != nil {
+		return err
+	}
+	if minCoverage == 0 {
+		return nil
+	}
+
+	if !asset.hasCollateral() {
+		return fmt.Errorf("asset %v requires collateral covering at least %v times the amount", asset.ID, minCoverage)
+	}
+
+	coverage := float64(asset.Collateral.ValuationAmount) / float64(asset.Amount)
+	if coverage < minCoverage {
+		return fmt.Errorf("collateral coverage %.2f of asset %v is below the minimum of %v", coverage, asset.ID, minCoverage)
+	}
+
+	return nil
+}
+
+// verifyLenderLoanLimit checks that issuing newLoans more loans keeps the lender within the configured
+// maximum number of active loans.
+func verifyLenderLoanLimit(ctx contractapi.TransactionContextInterface, lender string, newLoans int) error {
+	var max int
+	_, err := getConfig(ctx, configMaxLoans, &max)
+	if err != nil {
+		return err
+	}
+	if max == 0 {
+		return nil
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return err
+	}
+
+	active := 0
+	for _, asset := range assets {
+		if asset.isActive() && normalizeIdentity(asset.Lender) == lender {
+			active++
+		}
+	}
+	if active+newLoans > max {
+		return fmt.Errorf("lender already holds %v active loans, the maximum is %v", active, max)
+	}
+
+	return nil
+}
+
+// verifyNoActiveLoanFromLender checks, when the one loan per borrower policy is enabled, that the
+// borrower holds no active loan from the lender yet.
+func verifyNoActiveLoanFromLender(ctx contractapi.TransactionContextInterface, lender string, borrower string) error {
+	var enabled bool
+	_, err := getConfig(ctx, configOneLoanPerPair, &enabled)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, asset := range assets {
+		if asset.isActive() && normalizeIdentity(asset.Lender) == lender && normalizeIdentity(asset.Borrower) == borrower {
+			return fmt.Errorf("borrower %v already has active loan %v from the same lender", borrower, asset.ID)
+		}
+	}
+
+	return nil
+}
+
+// SetRoundingMode sets how interest amounts are rounded to a whole unit: "floor" rounds down, "ceil"
+// rounds up and "nearest" rounds half away from zero. It can only be called by an admin.
+func (s *SmartContract) SetRoundingMode(ctx contractapi.TransactionContextInterface, mode string) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := roundingFuncs[mode]; !ok {
+		return fmt.Errorf("rounding mode %q is not one of floor, ceil or nearest", mode)
+	}
+
+	log.Printf("SetRoundingMode Put: %v", mode)
+	return putConfig(ctx, configRoundingMode, mode)
+}
+
+// getRounding returns the function rounding interest amounts under the configured rounding mode.
+func getRounding(ctx contractapi.TransactionContextInterface) (func(float64) float64, error) {
+	mode := roundingNearest
+	_, err := getConfig(ctx, configRoundingMode, &mode)
+	if err != nil {
+		return nil, err
+	}
+
+	round, ok := roundingFuncs[mode]
+	if !ok {
+		return nil, fmt.Errorf("rounding mode %q is not supported", mode)
+	}
+
+	return round, nil
+}
+
+// verifyLoanTerm checks the term between start and end against the configured maximum loan term.
+func verifyLoanTerm(ctx contractapi.TransactionContextInterface, start int, end int) error {
+	var maxDays int
+	_, err := getConfig(ctx, configMaxLoanTermDays, &maxDays)
+	if err != nil {
+		return err
+	}
+	if maxDays == 0 {
+		return nil
+	}
+
+	days, err := daysBetween(start, end)
+	if err != nil {
+		return err
+	}
+	if days > maxDays {
+		return fmt.Errorf("loan term of %v days exceeds the maximum of %v days", days, maxDays)
+	}
+
+	return nil
+}
+
+// getConfig reads the named setting into value. It returns false if the setting has never been set.
+func getConfig(ctx contractapi.TransactionContextInterface, name string, value interface{}) (bool, error) {
+	configKey, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return false, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	configJSON, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read setting %v: %v", name, err)
+	}
+	if configJSON == nil {
+		return false, nil
+	}
+
+	err = json.Unmarshal(configJSON, value)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	return true, nil
+}
+
+// putConfig writes the named setting to the world state.
+func putConfig(ctx contractapi.TransactionContextInterface, name string, value interface{}) error {
+	configKey, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	configJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to create setting JSON: %v", err)
+	}
+
+	return ctx.GetStub().PutState(configKey, configJSON)
+}
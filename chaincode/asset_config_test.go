@@ -0,0 +1,235 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetMaxLoanTermDays(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	err := ledger.contract.SetMaxLoanTermDays(ledger.tx(testLender), 365)
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+
+	err = ledger.contract.SetMaxLoanTermDays(ledger.tx(testAdmin), -1)
+	require.EqualError(t, err, "maximum loan term must be zero or a positive integer")
+
+	require.NoError(t, ledger.contract.SetMaxLoanTermDays(ledger.tx(testAdmin), 365))
+
+	_, err = ledger.issueTestAsset("long", 1000, 20210101, 20220102)
+	require.EqualError(t, err, "loan term of 366 days exceeds the maximum of 365 days")
+
+	_, err = ledger.issueTestAsset("short", 1000, 20210101, 20220101)
+	require.NoError(t, err)
+
+	require.NoError(t, ledger.contract.SetMaxLoanTermDays(ledger.tx(testAdmin), 0))
+	_, err = ledger.issueTestAsset("long", 1000, 20210101, 20220102)
+	require.NoError(t, err)
+}
+
+func TestSetBlackoutDates(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	err := ledger.contract.SetBlackoutDates(ledger.tx(testLender), []int{20210601})
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+
+	err = ledger.contract.SetBlackoutDates(ledger.tx(testAdmin), []int{20211225, 20210230})
+	require.EqualError(t, err, "date 20210230 is not a valid YYYYMMDD date")
+
+	require.NoError(t, ledger.contract.SetBlackoutDates(ledger.tx(testAdmin), []int{20210601, 20211225}))
+
+	_, err = ledger.issueTestAsset("loan1", 1000, 20210601, 20211231)
+	require.EqualError(t, err, "assets cannot be issued on blackout date 20210601")
+
+	ledger.advance(24 * time.Hour)
+	_, err = ledger.issueTestAsset("loan1", 1000, 20210601, 20211231)
+	require.NoError(t, err)
+
+	ledger.advance(-24 * time.Hour)
+	require.NoError(t, ledger.contract.SetBlackoutDates(ledger.tx(testAdmin), []int{}))
+	_, err = ledger.issueTestAsset("loan2", 1000, 20210601, 20211231)
+	require.NoError(t, err)
+}
+
+func TestSetCoolingOffPeriod(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	err := ledger.contract.SetCoolingOffPeriod(ledger.tx(testAdmin), -1)
+	require.EqualError(t, err, "cooling-off period must be zero or a positive integer")
+	require.NoError(t, ledger.contract.SetCoolingOffPeriod(ledger.tx(testAdmin), 3600))
+
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+	ledger.advance(time.Hour)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+
+	ledger.advance(time.Hour - time.Second)
+	err = ledger.contract.BeginTrading(ledger.tx(testLender), "loan1")
+	require.EqualError(t, err, "asset loan1 is within its cooling-off period of 3600 seconds")
+	require.Equal(t, PENDING, ledger.getTestAsset("loan1").State)
+
+	ledger.advance(time.Second)
+	require.NoError(t, ledger.contract.BeginTrading(ledger.tx(testLender), "loan1"))
+	require.Equal(t, TRADING, ledger.getTestAsset("loan1").State)
+}
+
+func TestBeginTradingWithoutCoolingOffPeriod(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+
+	err := ledger.contract.BeginTrading(ledger.tx(testOutsider), "loan1")
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	require.NoError(t, ledger.contract.BeginTrading(ledger.tx(testLender), "loan1"))
+
+	err = ledger.contract.BeginTrading(ledger.tx(testLender), "loan2")
+	require.EqualError(t, err, "asset loan2 is ISSUED, only PENDING assets can begin trading")
+}
+
+func TestSetRegions(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	// a fresh network has no region list and accepts any region
+	_, err := ledger.issueTestAsset("loan1", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+
+	err = ledger.contract.SetRegions(ledger.tx(testLender), []string{"US"})
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+
+	err = ledger.contract.SetRegions(ledger.tx(testAdmin), []string{"US", ""})
+	require.EqualError(t, err, "regions must be non-empty strings")
+
+	require.NoError(t, ledger.contract.SetRegions(ledger.tx(testAdmin), []string{"US"}))
+	_, err = ledger.issueTestAsset("loan2", 1000, 20210101, 20211231)
+	require.EqualError(t, err, `region "EU" is not in the list of allowed regions`)
+
+	require.NoError(t, ledger.contract.SetRegions(ledger.tx(testAdmin), []string{"US", testRegion}))
+	_, err = ledger.issueTestAsset("loan2", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+
+	require.NoError(t, ledger.contract.SetRegions(ledger.tx(testAdmin), []string{}))
+	_, err = ledger.issueTestAsset("loan3", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+}
+
+func TestSetMaxLoansPerLender(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	err := ledger.contract.SetMaxLoansPerLender(ledger.tx(testAdmin), -1)
+	require.EqualError(t, err, "maximum loans per lender must be zero or a positive integer")
+	require.NoError(t, ledger.contract.SetMaxLoansPerLender(ledger.tx(testAdmin), 2))
+
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+	_, err = ledger.issueTestAsset("loan1", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+	_, err = ledger.issueTestAsset("loan2", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+
+	_, err = ledger.issueTestAsset("loan3", 1000, 20210101, 20211231)
+	require.EqualError(t, err, "lender already holds 2 active loans, the maximum is 2")
+
+	definitions := `[{"assetID":"loan3","amount":1000,"startDate":20210101,"endDate":20211231}]`
+	_, err = ledger.contract.CreateAssets(ledger.tx(testLender), definitions)
+	require.EqualError(t, err, "asset loan3: lender already holds 2 active loans, the maximum is 2")
+
+	// loans of other lenders do not count towards the limit
+	other := newTestAsset("other", TRADING)
+	other.Lender = testInvestor.id()
+	ledger.putTestAsset(other)
+	ledger.putTestAsset(newTestAsset("loan2", REDEEMED))
+	_, err = ledger.issueTestAsset("loan3", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+}
+
+func TestSetOneLoanPerBorrower(t *testing.T) {
+	ledger := newTestLedger(t)
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testBorrower.id()))
+
+	err := ledger.contract.SetOneLoanPerBorrower(ledger.tx(testLender), true)
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+
+	// without the policy a borrower can hold several loans from the same lender
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan2", testBorrower.id(), "borrower-wallet"))
+
+	require.NoError(t, ledger.contract.SetOneLoanPerBorrower(ledger.tx(testAdmin), true))
+	ledger.putTestAsset(newTestAsset("loan3", ISSUED))
+	err = ledger.contract.AssignBorrower(ledger.tx(testLender), "loan3", testBorrower.id(), "borrower-wallet")
+	require.EqualError(t, err, "borrower "+testBorrower.id()+" already has active loan loan1 from the same lender")
+	require.Equal(t, ISSUED, ledger.getTestAsset("loan3").State)
+
+	// loans that are no longer active and loans from other lenders do not count
+	ledger.putTestAsset(newTestAsset("loan1", REDEEMED))
+	ledger.putTestAsset(newTestAsset("loan2", REDEEMED))
+	other := newTestAsset("other", TRADING)
+	other.Lender = testInvestor.id()
+	ledger.putTestAsset(other)
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan3", testBorrower.id(), "borrower-wallet"))
+	require.Equal(t, PENDING, ledger.getTestAsset("loan3").State)
+}
+
+func TestSetMinCollateralCoverage(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	err := ledger.contract.SetMinCollateralCoverage(ledger.tx(testAdmin), -0.5)
+	require.EqualError(t, err, "minimum collateral coverage must be zero or a positive number")
+	require.NoError(t, ledger.contract.SetMinCollateralCoverage(ledger.tx(testAdmin), 1.5))
+
+	issue := func(assetID string, valuation int64) error {
+		transient := map[string]interface{}{assetID: AssetPrivate{SecretMessage: "terms of " + assetID}}
+		if valuation != 0 {
+			transient["collateral"] = Collateral{Description: "warehouse", ValuationAmount: valuation, ValuationDate: 20210101}
+		}
+		_, err := ledger.contract.IssueAsset(ledger.txWithTransient(testLender, transient), assetID, 1000, 20210101, 20211231, testRegion, "")
+		return err
+	}
+
+	require.EqualError(t, issue("unsecured", 0), "asset unsecured requires collateral covering at least 1.5 times the amount")
+	require.EqualError(t, issue("insufficient", 1400), "collateral coverage 1.40 of asset insufficient is below the minimum of 1.5")
+
+	require.NoError(t, issue("sufficient", 1500))
+	require.Equal(t, int64(1500), ledger.getTestAsset("sufficient").Collateral.ValuationAmount)
+}
+
+func TestSetRoundingMode(t *testing.T) {
+	ledger := newTestLedger(t)
+	asset := newTestAsset("loan1", TRADING)
+	asset.Amount = 10000
+	asset.EndDate = 20210701
+	ledger.putTestAsset(asset)
+
+	err := ledger.contract.SetRoundingMode(ledger.tx(testLender), roundingFloor)
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+	err = ledger.contract.SetRoundingMode(ledger.tx(testAdmin), "bankers")
+	require.EqualError(t, err, `rounding mode "bankers" is not one of floor, ceil or nearest`)
+
+	// 10000 * 5% * 181/365 = 247.95 in interest
+	for mode, expected := range map[string]int{roundingFloor: 10247, roundingCeil: 10248, roundingNearest: 10248} {
+		require.NoError(t, ledger.contract.SetRoundingMode(ledger.tx(testAdmin), mode))
+		repayment, err := ledger.contract.CalculateRepayment(ledger.tx(testOutsider), "loan1", 5)
+		require.NoError(t, err)
+		require.Equal(t, expected, repayment, "mode %v", mode)
+	}
+
+	// interest of 33.15, 22.11 and 11.16 on 1000 at 10% over three periods
+	ledger.putTestAsset(newTestAsset("loan2", TRADING))
+	for mode, expected := range map[string][]int{roundingFloor: {33, 22, 11}, roundingCeil: {34, 23, 12}, roundingNearest: {33, 22, 11}} {
+		require.NoError(t, ledger.contract.SetRoundingMode(ledger.tx(testAdmin), mode))
+		schedule, err := ledger.contract.GenerateRepaymentSchedule(ledger.tx(testBorrower), "loan2", 3, 10)
+		require.NoError(t, err)
+		require.Len(t, schedule, 3)
+		for i, installment := range schedule {
+			require.Equal(t, expected[i], installment.Interest, "mode %v, installment %v", mode, i+1)
+			require.Equal(t, installment.Principal+installment.Interest, installment.Total)
+		}
+	}
+}
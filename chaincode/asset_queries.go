@@ -7,37 +7,50 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang/protobuf/ptypes"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 )
 
-// ReadAsset reads the information from collection
+// ReadAsset reads an asset from the world state, returning an error if it does not exist
 func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, assetID string) (*Asset, error) {
 
-	log.Printf("ReadAsset: collection %v, ID %v", assetCollection, assetID)
-	assetJSON, err := ctx.GetStub().GetPrivateData(assetCollection, assetID) //get the asset from chaincode state
+	err := validateAssetID(assetID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read asset: %v", err)
-	}	github.com/2cluster/cc-asset-loan v0.0.0-20210212153857-9c1e67367604
-
-	//No Asset found, return empty response
-	if assetJSON == nil {
-		log.Printf("%v does not exist in collection %v", assetID, assetCollection)
-		return nil, nil
+		return nil, err
 	}
 
-	var asset *Asset
-	err = json.Unmarshal(assetJSON, &asset)
+	log.Printf("ReadAsset: ID %v", assetID)
+	return readAsset(ctx, assetID)
+}
+
+// TryReadAsset reads an asset from the world state. It returns a nil asset without an error if the asset
+// does not exist, so callers can branch on existence; errors are only returned for ledger failures.
+// A nil asset takes the place of a separate found flag because contract functions can return at most
+// two values, so a (*Asset, bool, error) signature would make the chaincode fail to start.
+func (s *SmartContract) TryReadAsset(ctx contractapi.TransactionContextInterface, assetID string) (*Asset, error) {
+
+	err := validateAssetID(assetID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		return nil, err
 	}
 
-	return asset, nil
-
+	return getAsset(ctx, assetID)
 }
 
 // ReadAssetPrivateDetails reads the asset private details in organization specific collection
@@ -96,24 +109,2140 @@ func (s *SmartContract) GetAssetByRange(ctx contractapi.TransactionContextInterf
 	}
 	defer resultsIterator.Close()
 
+	return constructQueryResponseFromIterator(resultsIterator)
+
+}
+
+// maxQuerySelectorSize caps the size of a client supplied query selector in bytes
+const maxQuerySelectorSize = 4096
+
+// QueryAssets runs an ad hoc rich query using a CouchDB selector supplied by the client,
+// e.g. {"selector":{"amount":{"$gte":1000}}}. The selector is combined with a match on the loan asset
+// objectType, so other documents in the world state are never returned.
+// Rich queries are only supported when the peers use CouchDB as their state database.
+func (s *SmartContract) QueryAssets(ctx contractapi.TransactionContextInterface, selectorJSON string) ([]*Asset, error) {
+
+	if len(selectorJSON) > maxQuerySelectorSize {
+		return nil, fmt.Errorf("query selector exceeds the maximum size of %v bytes", maxQuerySelectorSize)
+	}
+
+	var query map[string]interface{}
+	err := json.Unmarshal([]byte(selectorJSON), &query)
+	if err != nil {
+		return nil, fmt.Errorf("query selector must be a well-formed JSON object: %v", err)
+	}
+
+	selector, ok := query["selector"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("query must contain a selector object")
+	}
+	query["selector"] = map[string]interface{}{
+		"$and": []interface{}{map[string]interface{}{"objectType": loanAssetType}, selector},
+	}
+
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query JSON: %v", err)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructQueryResponseFromIterator(resultsIterator)
+}
+
+// GetAssetsEnteredStateAfter returns the assets whose most recent entry into the given state,
+// as recorded in the asset history, happened after the supplied unix time.
+func (s *SmartContract) GetAssetsEnteredStateAfter(ctx contractapi.TransactionContextInterface, state string, afterUnix int64) ([]*Asset, error) {
+
+	target, err := parseState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	results := []*Asset{}
+	for _, asset := range assets {
+		transitions, err := getStateTransitions(ctx, asset.ID)
+		if err != nil {
+			return nil, err
+		}
 
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
+		entered, ok := lastEnteredState(transitions, target)
+		if ok && entered.Unix() > afterUnix {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// GetTradeableAssets returns the PENDING and TRADING assets that can be listed on the secondary
+// market at currentDate (YYYYMMDD), i.e. assets that have not yet reached their maturity date.
+// Assets do not record disputes or suspensions, so no asset is excluded for those. A loan only becomes
+// overdue once it is past its maturity date, so overdue loans are excluded by the maturity check.
+func (s *SmartContract) GetTradeableAssets(ctx contractapi.TransactionContextInterface, currentDate int) ([]*Asset, error) {
+
+	_, err := parseDate(currentDate)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.State != PENDING && asset.State != TRADING {
+			continue
+		}
+		if asset.EndDate <= currentDate {
+			continue
+		}
+		results = append(results, asset)
+	}
+
+	return results, nil
+}
+
+// GetAssetsByRating returns the assets carrying the given credit rating
+func (s *SmartContract) GetAssetsByRating(ctx contractapi.TransactionContextInterface, rating string) ([]*Asset, error) {
+
+	err := validateRating(rating)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.Rating == rating {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// ComputeLedgerHash returns a hex encoded SHA-256 hash over the JSON of every asset in asset ID order.
+// Clients can compare the hash returned by different peers to detect diverging or tampered state.
+func (s *SmartContract) ComputeLedgerHash(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, asset := range assets {
+		assetJSON, err := json.Marshal(asset)
+		if err != nil {
+			return "", fmt.Errorf("failed to create asset JSON: %v", err)
+		}
+		hash.Write(assetJSON)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ReadAssetForCaller returns an asset with only the fields the submitting client is allowed to see.
+// The lender sees the whole asset, the borrower sees the loan terms, the parties and its own repayment
+// details, including its wallet address from the assetLoanCollection private data collection, and any
+// other client only sees the loan terms. Visible fields are copied from an allowlist,
+// so fields added to the asset later stay hidden from non-lenders until they are explicitly allowed.
+func (s *SmartContract) ReadAssetForCaller(ctx contractapi.TransactionContextInterface, assetID string) (*Asset, error) {
+
+	clientID, _, err := getClientOrgID(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch clientID {
+	case normalizeIdentity(asset.Lender):
+		return asset, nil
+	case normalizeIdentity(asset.Borrower):
+		view := borrowerView(asset)
+
+		addresses, err := getPrivateAddresses(ctx, assetID)
 		if err != nil {
 			return nil, err
 		}
+		if addresses != nil && len(addresses.BorrowerAddress) != 0 {
+			view.BorrowerAddress = addresses.BorrowerAddress
+		}
+
+		return view, nil
+	default:
+		return loanTermsView(asset), nil
+	}
+}
+
+// ReadAssetInCurrency reads an asset and returns a copy with its amount and collateral valuation
+// converted to targetCurrency at the rate rateNum/rateDen units of targetCurrency per unit of the loan
+// currency. Converted amounts are rounded down to whole units. The stored asset is not changed.
+func (s *SmartContract) ReadAssetInCurrency(ctx contractapi.TransactionContextInterface, assetID string, targetCurrency string, rateNum int64, rateDen int64) (*Asset, error) {
+
+	if rateNum <= 0 || rateDen <= 0 {
+		return nil, fmt.Errorf("exchange rate numerator and denominator must be positive integers")
+	}
+
+	if len(targetCurrency) == 0 {
+		return nil, fmt.Errorf("target currency must be a non-empty string")
+	}
+	targetCurrency, err := validateCurrency(targetCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := convertAmount(int64(asset.Amount), rateNum, rateDen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert amount of asset %v: %v", assetID, err)
+	}
+	valuation, err := convertAmount(asset.Collateral.ValuationAmount, rateNum, rateDen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert collateral valuation of asset %v: %v", assetID, err)
+	}
+
+	asset.Amount = int(amount)
+	asset.Collateral.ValuationAmount = valuation
+	asset.Currency = targetCurrency
+
+	return asset, nil
+}
+
+// GetModifiableAssets returns the assets the submitting client may change: those it lends or borrows
+// on, or every asset if it holds the admin role.
+func (s *SmartContract) GetModifiableAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+
+	clientID, _, err := getClientOrgID(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if verifyClientRole(ctx, roleAdmin) == nil {
+		return assets, nil
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if clientID == normalizeIdentity(asset.Lender) || (len(asset.Borrower) != 0 && clientID == normalizeIdentity(asset.Borrower)) {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// CountDistinctBorrowers returns the number of unique borrowers across all active assets
+func (s *SmartContract) CountDistinctBorrowers(ctx contractapi.TransactionContextInterface) (int, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	borrowers := make(map[string]bool)
+	for _, asset := range assets {
+		if !asset.isActive() || len(asset.Borrower) == 0 {
+			continue
+		}
+		borrowers[normalizeIdentity(asset.Borrower)] = true
+	}
+
+	return len(borrowers), nil
+}
+
+// DetectRapidTransitions returns the assets whose history shows them moving from ISSUED to TRADING
+// within maxSeconds, which may indicate a loan being cycled through its lifecycle to game the market.
+func (s *SmartContract) DetectRapidTransitions(ctx contractapi.TransactionContextInterface, maxSeconds int64) ([]*Asset, error) {
 
-		var asset *Asset
-		err = json.Unmarshal(response.Value, &asset)
+	if maxSeconds <= 0 {
+		return nil, fmt.Errorf("maxSeconds must be a positive integer")
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		transitions, err := getStateTransitions(ctx, asset.ID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+			return nil, err
 		}
 
-		results = append(results, asset)
+		var issued time.Time
+		for _, transition := range transitions {
+			if transition.state == ISSUED {
+				issued = transition.timestamp
+				continue
+			}
+			if transition.state == TRADING && !issued.IsZero() {
+				if transition.timestamp.Sub(issued) <= time.Duration(maxSeconds)*time.Second {
+					results = append(results, asset)
+				}
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// GetAssetsByStateMaturingBefore returns the assets in the given state whose end date is before beforeDate (YYYYMMDD)
+func (s *SmartContract) GetAssetsByStateMaturingBefore(ctx contractapi.TransactionContextInterface, state string, beforeDate int) ([]*Asset, error) {
+
+	target, err := parseState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = parseDate(beforeDate)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.State == target && asset.EndDate < beforeDate {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// GetAssetsByDaysToMaturity returns the active assets maturing between minDays and maxDays days, inclusive,
+// after currentDate (YYYYMMDD). Negative days select loans that are already past their end date.
+func (s *SmartContract) GetAssetsByDaysToMaturity(ctx contractapi.TransactionContextInterface, currentDate int, minDays int, maxDays int) ([]*Asset, error) {
+
+	if minDays > maxDays {
+		return nil, fmt.Errorf("minDays %v must not be greater than maxDays %v", minDays, maxDays)
+	}
+
+	_, err := parseDate(currentDate)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if !asset.isActive() {
+			continue
+		}
+
+		days, err := daysBetween(currentDate, asset.EndDate)
+		if err != nil {
+			return nil, fmt.Errorf("asset %v: %v", asset.ID, err)
+		}
+		if days >= minDays && days <= maxDays {
+			results = append(results, asset)
+		}
 	}
 
 	return results, nil
+}
+
+// Actions recommended to loan servicers by GetRecommendedAction
+const (
+	actionDisburse      = "disburse"
+	actionBeginTrading  = "begin trading"
+	actionRecordPayment = "record payment"
+	actionMarkOverdue   = "mark overdue"
+	actionRedeem        = "redeem"
+	actionNone          = "none"
+)
+
+// GetRecommendedAction suggests the next servicing action for an asset at currentDate (YYYYMMDD),
+// based on its lifecycle state, maturity date and recorded payments.
+func (s *SmartContract) GetRecommendedAction(ctx contractapi.TransactionContextInterface, assetID string, currentDate int) (string, error) {
+
+	_, err := parseDate(currentDate)
+	if err != nil {
+		return "", err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return "", err
+	}
+
+	return recommendedAction(asset, currentDate), nil
+}
 
+// WorkItem is an asset in the servicing work queue together with its next action and when it is due
+type WorkItem struct {
+	Asset   *Asset `json:"asset"`
+	Action  string `json:"action"`
+	DueDate int    `json:"dueDate"`
+	Overdue bool   `json:"overdue"`
 }
 
+// GetWorkQueue returns every asset that needs a servicing action at currentDate (YYYYMMDD), most urgent
+// first. Actions before trading are due on the start date of the loan and later ones on its end date,
+// so overdue items sort ahead of upcoming ones.
+func (s *SmartContract) GetWorkQueue(ctx contractapi.TransactionContextInterface, currentDate int) ([]WorkItem, error) {
+
+	_, err := parseDate(currentDate)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := []WorkItem{}
+	for _, asset := range assets {
+		action := recommendedAction(asset, currentDate)
+		if action == actionNone {
+			continue
+		}
+
+		dueDate := asset.EndDate
+		if asset.State == ISSUED || asset.State == PENDING {
+			dueDate = asset.StartDate
+		}
+
+		queue = append(queue, WorkItem{
+			Asset:   asset,
+			Action:  action,
+			DueDate: dueDate,
+			Overdue: dueDate < currentDate,
+		})
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].DueDate != queue[j].DueDate {
+			return queue[i].DueDate < queue[j].DueDate
+		}
+		return queue[i].Asset.ID < queue[j].Asset.ID
+	})
+
+	return queue, nil
+}
+
+// DaysInCurrentState returns the number of days between the asset entering its current state,
+// as recorded in the asset history, and currentDate (YYYYMMDD).
+func (s *SmartContract) DaysInCurrentState(ctx contractapi.TransactionContextInterface, assetID string, currentDate int) (int, error) {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return 0, err
+	}
+
+	transitions, err := getStateTransitions(ctx, assetID)
+	if err != nil {
+		return 0, err
+	}
+
+	entered, ok := lastEnteredState(transitions, asset.State)
+	if !ok {
+		return 0, fmt.Errorf("history of asset %v does not record it entering state %v", assetID, asset.State)
+	}
+
+	days, err := daysBetween(toDate(entered), currentDate)
+	if err != nil {
+		return 0, err
+	}
+	if days < 0 {
+		return 0, fmt.Errorf("current date %v is before asset %v entered state %v", currentDate, assetID, asset.State)
+	}
+
+	return days, nil
+}
+
+// Change types reported in the change feed
+const (
+	changeCreate = "CREATE"
+	changeUpdate = "UPDATE"
+	changeDelete = "DELETE"
+)
+
+// ChangeEvent describes a single modification of an asset
+type ChangeEvent struct {
+	AssetID    string    `json:"assetID"`
+	TxID       string    `json:"txID"`
+	ChangeType string    `json:"changeType"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// ChangeFeedPage is a page of change events together with the bookmark of the next page
+type ChangeFeedPage struct {
+	Events   []ChangeEvent `json:"events"`
+	Bookmark string        `json:"bookmark"`
+}
+
+// GetChangeFeed returns a page of the changes made to assets after sinceUnix, ordered by time.
+// The events are derived from the history of every asset currently in the world state.
+// Pass an empty bookmark for the first page and the returned bookmark for each following page;
+// an empty bookmark in the result means there are no more events.
+func (s *SmartContract) GetChangeFeed(ctx contractapi.TransactionContextInterface, sinceUnix int64, pageSize int32, bookmark string) (*ChangeFeedPage, error) {
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be a positive integer")
+	}
+
+	offset := 0
+	if len(bookmark) != 0 {
+		var err error
+		offset, err = strconv.Atoi(bookmark)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid bookmark %q", bookmark)
+		}
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []ChangeEvent{}
+	for _, asset := range assets {
+		revisions, err := getAssetRevisions(ctx, asset.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		exists := false
+		for _, revision := range revisions {
+			changeType := changeUpdate
+			if revision.isDelete {
+				changeType = changeDelete
+			} else if !exists {
+				changeType = changeCreate
+			}
+			exists = !revision.isDelete
+
+			if revision.timestamp.Unix() <= sinceUnix {
+				continue
+			}
+			events = append(events, ChangeEvent{
+				AssetID:    asset.ID,
+				TxID:       revision.txID,
+				ChangeType: changeType,
+				Timestamp:  revision.timestamp,
+			})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+
+	page := &ChangeFeedPage{Events: []ChangeEvent{}}
+	if offset >= len(events) {
+		return page, nil
+	}
+
+	end := offset + int(pageSize)
+	if end < len(events) {
+		page.Bookmark = strconv.Itoa(end)
+	} else {
+		end = len(events)
+	}
+	page.Events = events[offset:end]
+
+	return page, nil
+}
+
+// Outcomes reported in a loan journey
+const (
+	outcomeOpen     = "open"
+	outcomeRedeemed = "redeemed"
+)
+
+// StateChange records the time an asset entered a state
+type StateChange struct {
+	State     string    `json:"state"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// LoanJourney summarizes the lifecycle of a loan as replayed from its history
+type LoanJourney struct {
+	AssetID      string        `json:"assetID"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	DisbursedAt  time.Time     `json:"disbursedAt"`
+	Transitions  []StateChange `json:"transitions"`
+	PaymentCount int           `json:"paymentCount"`
+	Outcome      string        `json:"outcome"`
+}
+
+// BuildLoanJourney replays the history of an asset into a LoanJourney. The loan counts as
+// disbursed once it first left ISSUED; DisbursedAt is the zero time while it has not.
+func (s *SmartContract) BuildLoanJourney(ctx contractapi.TransactionContextInterface, assetID string) (*LoanJourney, error) {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	transitions, err := getStateTransitions(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	journey := &LoanJourney{
+		AssetID:      assetID,
+		Transitions:  []StateChange{},
+		PaymentCount: len(asset.PaymentHashes),
+		Outcome:      outcomeOpen,
+	}
+
+	for i, transition := range transitions {
+		if i == 0 {
+			journey.CreatedAt = transition.timestamp
+		} else if journey.DisbursedAt.IsZero() && transition.state != ISSUED {
+			journey.DisbursedAt = transition.timestamp
+		}
+		if transition.state == REDEEMED {
+			journey.Outcome = outcomeRedeemed
+		}
+
+		journey.Transitions = append(journey.Transitions, StateChange{
+			State:     transition.state.String(),
+			Timestamp: transition.timestamp,
+		})
+	}
+
+	return journey, nil
+}
+
+// GetTotalValueLocked returns the sum of the amounts of all active loans. The amounts can only be added
+// up if every active loan is in the same currency, so a book with mixed currencies is rejected.
+func (s *SmartContract) GetTotalValueLocked(ctx contractapi.TransactionContextInterface) (int64, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	active := activeAssets(assets)
+	err = verifySingleCurrency(active)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, asset := range active {
+		total += int64(asset.Amount)
+	}
+
+	return total, nil
+}
+
+// GetArchivedAssets returns every asset moved to the archive namespace by ArchiveRedeemedAsset
+func (s *SmartContract) GetArchivedAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(archiveObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructQueryResponseFromIterator(resultsIterator)
+}
+
+// GetArchivedAsset reads an asset from the archive namespace
+func (s *SmartContract) GetArchivedAsset(ctx contractapi.TransactionContextInterface, assetID string) (*Asset, error) {
+
+	archiveKey, err := ctx.GetStub().CreateCompositeKey(archiveObjectType, []string{assetID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	assetJSON, err := ctx.GetStub().GetState(archiveKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if assetJSON == nil {
+		return nil, fmt.Errorf("archived asset %v does not exist", assetID)
+	}
+
+	return unmarshalAsset(assetJSON)
+}
+
+// ValidateAllDates returns the IDs of the assets whose start or end date is not a valid YYYYMMDD
+// date, or whose end date is not after the start date.
+func (s *SmartContract) ValidateAllDates(ctx contractapi.TransactionContextInterface) ([]string, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := []string{}
+	for _, asset := range assets {
+		_, startErr := parseDate(asset.StartDate)
+		_, endErr := parseDate(asset.EndDate)
+		if startErr != nil || endErr != nil || asset.EndDate <= asset.StartDate {
+			invalid = append(invalid, asset.ID)
+		}
+	}
+
+	return invalid, nil
+}
+
+// GetStatesVisited returns the distinct states an asset has passed through, in the order it first entered them
+func (s *SmartContract) GetStatesVisited(ctx contractapi.TransactionContextInterface, assetID string) ([]string, error) {
+
+	transitions, err := getStateTransitions(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	if len(transitions) == 0 {
+		return nil, fmt.Errorf("asset %v has no history", assetID)
+	}
+
+	visited := make(map[State]bool)
+	states := []string{}
+	for _, transition := range transitions {
+		if visited[transition.state] {
+			continue
+		}
+		visited[transition.state] = true
+		states = append(states, transition.state.String())
+	}
+
+	return states, nil
+}
+
+// GetAssetsByRegion returns the assets tagged with the given region
+func (s *SmartContract) GetAssetsByRegion(ctx contractapi.TransactionContextInterface, region string) ([]*Asset, error) {
+
+	if len(region) == 0 {
+		return nil, fmt.Errorf("region must be a non-empty string")
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.Region == region {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// GetStalePendingAssets returns the PENDING assets that entered PENDING more than maxPendingDays
+// before currentDate (YYYYMMDD), according to their history.
+func (s *SmartContract) GetStalePendingAssets(ctx contractapi.TransactionContextInterface, currentDate int, maxPendingDays int) ([]*Asset, error) {
+
+	_, err := parseDate(currentDate)
+	if err != nil {
+		return nil, err
+	}
+	if maxPendingDays < 0 {
+		return nil, fmt.Errorf("maxPendingDays must be zero or a positive integer")
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.State != PENDING {
+			continue
+		}
+
+		transitions, err := getStateTransitions(ctx, asset.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		entered, ok := lastEnteredState(transitions, PENDING)
+		if !ok {
+			continue
+		}
+
+		days, err := daysBetween(toDate(entered), currentDate)
+		if err != nil {
+			return nil, err
+		}
+		if days > maxPendingDays {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// MedianLoanAmount returns the median amount of all active loans, or 0 if there are none.
+// For an even number of loans the mean of the two middle amounts is returned, rounded down.
+// The active loans must all be in the same currency.
+func (s *SmartContract) MedianLoanAmount(ctx contractapi.TransactionContextInterface) (int64, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	active := activeAssets(assets)
+	err = verifySingleCurrency(active)
+	if err != nil {
+		return 0, err
+	}
+
+	var amounts []int64
+	for _, asset := range active {
+		amounts = append(amounts, int64(asset.Amount))
+	}
+	if len(amounts) == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(amounts, func(i, j int) bool {
+		return amounts[i] < amounts[j]
+	})
+
+	middle := len(amounts) / 2
+	if len(amounts)%2 == 1 {
+		return amounts[middle], nil
+	}
+	return (amounts[middle-1] + amounts[middle]) / 2, nil
+}
+
+// GetRestructuredAssets returns the assets that have had at least one restructure applied
+func (s *SmartContract) GetRestructuredAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.Restructured {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// GetUndercollateralizedAssets returns the collateralized assets whose collateral is valued below the loan amount
+func (s *SmartContract) GetUndercollateralizedAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.hasCollateral() && asset.Collateral.ValuationAmount < int64(asset.Amount) {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// ComputeLTV returns the loan-to-value ratio of an asset, i.e. its amount divided by the collateral valuation
+func (s *SmartContract) ComputeLTV(ctx contractapi.TransactionContextInterface, assetID string) (float64, error) {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return 0, err
+	}
+
+	return loanToValue(asset)
+}
+
+// QueryAssetsByState returns the assets in the given lifecycle state, e.g. "TRADING"
+func (s *SmartContract) QueryAssetsByState(ctx contractapi.TransactionContextInterface, state string) ([]*Asset, error) {
+
+	target, err := parseState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if asset.State == target {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// QueryAssetsByStates returns the assets in any of the given lifecycle states using a CouchDB rich query
+func (s *SmartContract) QueryAssetsByStates(ctx contractapi.TransactionContextInterface, states []string) ([]*Asset, error) {
+
+	if len(states) == 0 {
+		return nil, fmt.Errorf("at least one state must be given")
+	}
+
+	// states are stored by their numeric value in the currentState field
+	targets := make([]State, 0, len(states))
+	for _, state := range states {
+		target, err := parseState(state)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return getQueryResultForSelector(ctx, map[string]interface{}{
+		"currentState": map[string]interface{}{"$in": targets},
+	})
+}
+
+// QueryAssetsByStateWithPagination returns one page of pageSize assets in the given lifecycle state using
+// a CouchDB rich query. Pass an empty bookmark to read the first page.
+func (s *SmartContract) QueryAssetsByStateWithPagination(ctx contractapi.TransactionContextInterface, state string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+
+	target, err := parseState(state)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive number")
+	}
+
+	query, err := selectorQuery(map[string]interface{}{"currentState": target})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Assets:              assets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// CountAssetsByState returns the number of assets in the given lifecycle state without loading them all
+func (s *SmartContract) CountAssetsByState(ctx contractapi.TransactionContextInterface, state string) (int, error) {
+
+	target, err := parseState(state)
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(typeAsset, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		asset, err := decodeAsset(response.Value)
+		if err != nil {
+			return 0, err
+		}
+
+		if asset.State == target {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// QueryAssetsByLender returns the assets held by the given lender using a CouchDB rich query
+func (s *SmartContract) QueryAssetsByLender(ctx contractapi.TransactionContextInterface, lender string) ([]*Asset, error) {
+
+	lender = normalizeIdentity(lender)
+	if len(lender) == 0 {
+		return nil, fmt.Errorf("lender must be a non-empty string")
+	}
+
+	return getQueryResultForSelector(ctx, map[string]interface{}{"lender": lender})
+}
+
+// QueryAssetsByMSP returns the assets issued by members of the given organization using a CouchDB rich query
+func (s *SmartContract) QueryAssetsByMSP(ctx contractapi.TransactionContextInterface, mspID string) ([]*Asset, error) {
+
+	if len(mspID) == 0 {
+		return nil, fmt.Errorf("MSP ID must be a non-empty string")
+	}
+
+	return getQueryResultForSelector(ctx, map[string]interface{}{"issuerMSP": mspID})
+}
+
+// QueryAssetsByBorrower returns the assets of the given borrower using a CouchDB rich query
+func (s *SmartContract) QueryAssetsByBorrower(ctx contractapi.TransactionContextInterface, borrower string) ([]*Asset, error) {
+
+	borrower = normalizeIdentity(borrower)
+	if len(borrower) == 0 {
+		return nil, fmt.Errorf("borrower must be a non-empty string")
+	}
+
+	return getQueryResultForSelector(ctx, map[string]interface{}{"borrower": borrower})
+}
+
+// GetLTVBreaches returns the collateralized assets whose loan-to-value ratio exceeds maxLTV
+func (s *SmartContract) GetLTVBreaches(ctx contractapi.TransactionContextInterface, maxLTV float64) ([]*Asset, error) {
+
+	if maxLTV <= 0 {
+		return nil, fmt.Errorf("maxLTV must be a positive number")
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		ltv, err := loanToValue(asset)
+		if err != nil {
+			// uncollateralized loans carry no LTV covenant
+			continue
+		}
+		if ltv > maxLTV {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// HistoryEntry is one revision of an asset as recorded by the ledger history
+type HistoryEntry struct {
+	TxID      string    `json:"txID"`
+	Timestamp time.Time `json:"timestamp"`
+	Asset     *Asset    `json:"asset"`
+	IsDelete  bool      `json:"isDelete"`
+}
+
+// GetAssetHistory returns every revision of an asset, oldest first. The asset of a delete entry is nil.
+func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, assetID string) ([]HistoryEntry, error) {
+
+	revisions, err := getAssetRevisions(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := []HistoryEntry{}
+	for _, revision := range revisions {
+		history = append(history, HistoryEntry{
+			TxID:      revision.txID,
+			Timestamp: revision.timestamp,
+			Asset:     revision.asset,
+			IsDelete:  revision.isDelete,
+		})
+	}
+
+	return history, nil
+}
+
+// PaginatedQueryResult is a page of assets together with the bookmark of the next page
+type PaginatedQueryResult struct {
+	Assets              []*Asset `json:"assets"`
+	FetchedRecordsCount int32    `json:"fetchedRecordsCount"`
+	Bookmark            string   `json:"bookmark"`
+}
+
+// GetAllAssetsWithPagination returns one page of pageSize assets starting at the bookmark.
+// Pass an empty bookmark to read the first page.
+func (s *SmartContract) GetAllAssetsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive number")
+	}
+
+	// Assets are stored under composite keys, so page over the asset key prefix rather than a raw key range
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(typeAsset, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Assets:              assets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// BorrowerConcentration is a borrower's share of the total outstanding amount of active loans in a currency
+type BorrowerConcentration struct {
+	Borrower     string  `json:"borrower"`
+	Currency     string  `json:"currency"`
+	Outstanding  int     `json:"outstanding"`
+	Share        float64 `json:"share"`
+	ExceedsLimit bool    `json:"exceedsLimit"`
+}
+
+// GetConcentrationByBorrower returns each borrower's share of the total outstanding amount of active
+// loans per currency, ordered by currency and largest first. Borrowers above the limit configured with
+// SetConcentrationLimit are flagged.
+func (s *SmartContract) GetConcentrationByBorrower(ctx contractapi.TransactionContextInterface) ([]BorrowerConcentration, error) {
+
+	var limit float64
+	_, err := getConfig(ctx, configConcentration, &limit)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type exposure struct {
+		borrower string
+		currency string
+	}
+
+	totals := make(map[string]int)
+	outstanding := make(map[exposure]int)
+	for _, asset := range assets {
+		if !asset.isActive() || len(asset.Borrower) == 0 {
+			continue
+		}
+		outstanding[exposure{normalizeIdentity(asset.Borrower), asset.currency()}] += asset.Amount
+		totals[asset.currency()] += asset.Amount
+	}
+
+	results := []BorrowerConcentration{}
+	for key, amount := range outstanding {
+		share := float64(amount) / float64(totals[key.currency])
+		results = append(results, BorrowerConcentration{
+			Borrower:     key.borrower,
+			Currency:     key.currency,
+			Outstanding:  amount,
+			Share:        share,
+			ExceedsLimit: limit > 0 && share > limit,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Currency != results[j].Currency {
+			return results[i].Currency < results[j].Currency
+		}
+		if results[i].Outstanding != results[j].Outstanding {
+			return results[i].Outstanding > results[j].Outstanding
+		}
+		return results[i].Borrower < results[j].Borrower
+	})
+
+	return results, nil
+}
+
+// CalculateRepayment returns the total repayable on a loan, its amount plus simple interest
+// at annualRatePercent over the term between its start and end date. The interest is rounded to a whole
+// unit under the rounding mode set with SetRoundingMode, to the nearest unit by default.
+func (s *SmartContract) CalculateRepayment(ctx contractapi.TransactionContextInterface, assetID string, annualRatePercent float64) (int, error) {
+
+	if annualRatePercent < 0 {
+		return 0, fmt.Errorf("annual interest rate must not be negative")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return 0, err
+	}
+
+	days, err := daysBetween(asset.StartDate, asset.EndDate)
+	if err != nil {
+		return 0, err
+	}
+
+	round, err := getRounding(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	interest := float64(asset.Amount) * annualRatePercent / 100 * float64(days) / 365
+
+	return asset.Amount + int(round(interest)), nil
+}
+
+// QueryAssetsByStartDateRange returns the assets originated between from and to (YYYYMMDD), inclusive
+func (s *SmartContract) QueryAssetsByStartDateRange(ctx contractapi.TransactionContextInterface, from int, to int) ([]*Asset, error) {
+
+	_, err := parseDate(from)
+	if err != nil {
+		return nil, err
+	}
+	_, err = parseDate(to)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("from %v must not be after to %v", from, to)
+	}
+
+	return getQueryResultForSelector(ctx, map[string]interface{}{
+		"startDate": map[string]interface{}{"$gte": from, "$lte": to},
+	})
+}
+
+// QueryAssetsByAmountRange returns the assets with an amount between min and max, inclusive, ordered by amount
+func (s *SmartContract) QueryAssetsByAmountRange(ctx contractapi.TransactionContextInterface, min int, max int) ([]*Asset, error) {
+
+	if min > max {
+		return nil, fmt.Errorf("min %v must not be greater than max %v", min, max)
+	}
+
+	// Keys are compared lexically by GetStateByRange, so the numeric range is left to CouchDB
+	assets, err := getQueryResultForSelector(ctx, map[string]interface{}{
+		"amount": map[string]interface{}{"$gte": min, "$lte": max},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Amount != assets[j].Amount {
+			return assets[i].Amount < assets[j].Amount
+		}
+		return assets[i].ID < assets[j].ID
+	})
+
+	return assets, nil
+}
+
+// ReadAssets returns the assets with the given IDs in the order they were requested.
+// If any of the assets does not exist, an error listing every missing ID is returned instead.
+func (s *SmartContract) ReadAssets(ctx contractapi.TransactionContextInterface, assetIDs []string) ([]*Asset, error) {
+
+	results := []*Asset{}
+	var missing []string
+	for _, assetID := range assetIDs {
+		asset, err := getAsset(ctx, assetID)
+		if err != nil {
+			return nil, err
+		}
+		if asset == nil {
+			missing = append(missing, assetID)
+			continue
+		}
+		results = append(results, asset)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("assets do not exist: %v", strings.Join(missing, ", "))
+	}
+
+	return results, nil
+}
+
+// ReadPrivateAsset returns the private wallet addresses of an asset
+func (s *SmartContract) ReadPrivateAsset(ctx contractapi.TransactionContextInterface, assetID string) (*AssetPrivateAddresses, error) {
+
+	log.Printf("ReadPrivateAsset: collection %v, ID %v", assetLoanCollection, assetID)
+	addresses, err := getPrivateAddresses(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	if addresses == nil {
+		return nil, fmt.Errorf("private addresses of asset %v do not exist in collection %v", assetID, assetLoanCollection)
+	}
+
+	return addresses, nil
+}
+
+// GetMaturedAssets returns the PENDING or TRADING assets whose end date is on or before asOfDate (YYYYMMDD),
+// i.e. loans that have matured but have not been redeemed yet. An asOfDate of 0 uses the date of the transaction.
+func (s *SmartContract) GetMaturedAssets(ctx contractapi.TransactionContextInterface, asOfDate int) ([]*Asset, error) {
+
+	asOfDate, err := dateOrTxDate(ctx, asOfDate)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if (asset.State == PENDING || asset.State == TRADING) && asset.EndDate <= asOfDate {
+			results = append(results, asset)
+		}
+	}
+
+	return results, nil
+}
+
+// RegulatoryReport is a snapshot of the loan book for compliance filings. Amounts are keyed by currency.
+type RegulatoryReport struct {
+	AsOf             int            `json:"asOf"`
+	TotalLoans       int            `json:"totalLoans"`
+	TotalOutstanding map[string]int `json:"totalOutstanding"`
+	CountsByState    map[string]int `json:"countsByState"`
+	OverdueLoans     int            `json:"overdueLoans"`
+	OverdueAmount    map[string]int `json:"overdueAmount"`
+	WrittenOffLoans  int            `json:"writtenOffLoans"`
+}
+
+// GenerateRegulatoryReport summarizes the loan book as of asOf (YYYYMMDD). Outstanding amounts cover the
+// active loans, per currency, and an active loan is overdue once its end date is before asOf.
+func (s *SmartContract) GenerateRegulatoryReport(ctx contractapi.TransactionContextInterface, asOf int) (*RegulatoryReport, error) {
+
+	_, err := parseDate(asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RegulatoryReport{
+		AsOf:             asOf,
+		TotalLoans:       len(assets),
+		TotalOutstanding: make(map[string]int),
+		CountsByState:    make(map[string]int),
+		OverdueAmount:    make(map[string]int),
+	}
+	for _, asset := range assets {
+		report.CountsByState[asset.State.String()]++
+
+		if asset.State == WRITTEN_OFF {
+			report.WrittenOffLoans++
+		}
+		if !asset.isActive() {
+			continue
+		}
+
+		report.TotalOutstanding[asset.currency()] += asset.Amount
+		if asset.EndDate < asOf {
+			report.OverdueLoans++
+			report.OverdueAmount[asset.currency()] += asset.Amount
+		}
+	}
+
+	return report, nil
+}
+
+// maxDistributionDates caps the number of dates GetStateDistributionHistory accepts, as every
+// date requires replaying the history of every asset
+const maxDistributionDates = 12
+
+// StateDistribution is the number of assets per state at the end of a date
+type StateDistribution struct {
+	Date   int            `json:"date"`
+	Counts map[string]int `json:"counts"`
+}
+
+// GetStateDistributionHistory returns, for each of the given dates (YYYYMMDD), how many assets were in
+// each state at the end of that day, derived from the asset history. Assets that no longer exist in the
+// world state are not counted. This reads the full history of every asset, so at most
+// maxDistributionDates dates can be requested.
+func (s *SmartContract) GetStateDistributionHistory(ctx contractapi.TransactionContextInterface, dates []int) ([]StateDistribution, error) {
+
+	if len(dates) == 0 || len(dates) > maxDistributionDates {
+		return nil, fmt.Errorf("between 1 and %v dates must be given", maxDistributionDates)
+	}
+
+	distributions := make([]StateDistribution, len(dates))
+	for i, date := range dates {
+		_, err := parseDate(date)
+		if err != nil {
+			return nil, err
+		}
+		distributions[i] = StateDistribution{Date: date, Counts: make(map[string]int)}
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, asset := range assets {
+		revisions, err := getAssetRevisions(ctx, asset.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, date := range dates {
+			// revisions are in chronological order, so the last one on or before the date applies
+			var current *assetRevision
+			for j := range revisions {
+				if toDate(revisions[j].timestamp) > date {
+					break
+				}
+				current = &revisions[j]
+			}
+			if current == nil || current.isDelete {
+				continue
+			}
+			distributions[i].Counts[current.asset.State.String()]++
+		}
+	}
+
+	return distributions, nil
+}
+
+// FindUnauthorizedTransitions returns the assets whose history shows a state change made by an identity
+// other than the lender or borrower of the asset at that point. The creation of an asset must be made by
+// its lender. Revisions written by an admin, e.g. by UnarchiveAsset or RestoreAssetFromHistory, and
+// revisions written before modifiers were recorded are not checked.
+func (s *SmartContract) FindUnauthorizedTransitions(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		revisions, err := getAssetRevisions(ctx, asset.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var previous *Asset
+		for _, revision := range revisions {
+			if revision.isDelete {
+				previous = nil
+				continue
+			}
+
+			current := revision.asset
+			if len(current.LastModifiedBy) != 0 && !current.LastModifiedByAdmin {
+				if previous == nil && current.LastModifiedBy != normalizeIdentity(current.Lender) {
+					results = append(results, asset)
+					break
+				}
+				if previous != nil && previous.State != current.State && !isPartyOf(previous, current.LastModifiedBy) {
+					results = append(results, asset)
+					break
+				}
+			}
+			previous = current
+		}
+	}
+
+	return results, nil
+}
+
+// GetAssetState returns the name of the current lifecycle state of an asset, e.g. "TRADING"
+func (s *SmartContract) GetAssetState(ctx contractapi.TransactionContextInterface, assetID string) (string, error) {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return "", err
+	}
+
+	return asset.State.String(), nil
+}
+
+// GetAssetEndorsingOrgs returns the MSP IDs of the organizations in the state-based endorsement policy of an asset
+func (s *SmartContract) GetAssetEndorsingOrgs(ctx contractapi.TransactionContextInterface, assetID string) ([]string, error) {
+
+	exists, err := assetExists(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("asset %v does not exist", assetID)
+	}
+
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{assetID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	policy, err := ctx.GetStub().GetStateValidationParameter(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validation parameter of asset %v: %v", assetID, err)
+	}
+	if policy == nil {
+		return []string{}, nil
+	}
+
+	endorsementPolicy, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endorsement policy of asset %v: %v", assetID, err)
+	}
+
+	orgs := endorsementPolicy.ListOrgs()
+	sort.Strings(orgs)
+
+	return orgs, nil
+}
+
+// Installment is one scheduled repayment of a loan
+type Installment struct {
+	DueDate   int `json:"dueDate"`
+	Principal int `json:"principal"`
+	Interest  int `json:"interest"`
+	Total     int `json:"total"`
+}
+
+// GenerateRepaymentSchedule splits the term of a loan into the given number of equal periods and returns
+// an installment for each. The principal is repaid in equal parts, with any rounding remainder in the last
+// installment, and interest is charged at annualRatePercent on the balance outstanding over each period,
+// rounded under the configured rounding mode.
+func (s *SmartContract) GenerateRepaymentSchedule(ctx contractapi.TransactionContextInterface, assetID string, installments int, annualRatePercent float64) ([]Installment, error) {
+
+	if installments <= 0 {
+		return nil, fmt.Errorf("installments must be a positive integer")
+	}
+	if annualRatePercent < 0 {
+		return nil, fmt.Errorf("annual interest rate must not be negative")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	startDate, err := parseDate(asset.StartDate)
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := daysBetween(asset.StartDate, asset.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	round, err := getRounding(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := asset.Amount / installments
+	outstanding := asset.Amount
+	previousDay := 0
+
+	schedule := make([]Installment, 0, installments)
+	for i := 1; i <= installments; i++ {
+		day := days * i / installments
+		interest := int(round(float64(outstanding) * annualRatePercent / 100 * float64(day-previousDay) / 365))
+
+		installmentPrincipal := principal
+		if i == installments {
+			installmentPrincipal = outstanding
+		}
+
+		schedule = append(schedule, Installment{
+			DueDate:   toDate(startDate.AddDate(0, 0, day)),
+			Principal: installmentPrincipal,
+			Interest:  interest,
+			Total:     installmentPrincipal + interest,
+		})
+
+		outstanding -= installmentPrincipal
+		previousDay = day
+	}
+
+	return schedule, nil
+}
+
+// PortfolioSummary aggregates the loan book for dashboards. Principal amounts are keyed by currency.
+type PortfolioSummary struct {
+	TotalAssets      int                `json:"totalAssets"`
+	TotalPrincipal   map[string]int     `json:"totalPrincipal"`
+	CountByState     map[string]int     `json:"countByState"`
+	AveragePrincipal map[string]float64 `json:"averagePrincipal"`
+}
+
+// GetPortfolioSummary returns the number of assets per state, and the total and average principal per
+// currency of the active assets, i.e. those not redeemed or written off, in a single pass over the ledger
+func (s *SmartContract) GetPortfolioSummary(ctx contractapi.TransactionContextInterface) (*PortfolioSummary, error) {
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(typeAsset, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	summary := &PortfolioSummary{
+		TotalPrincipal:   make(map[string]int),
+		CountByState:     make(map[string]int),
+		AveragePrincipal: make(map[string]float64),
+	}
+	outstanding := make(map[string]int)
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := decodeAsset(response.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.TotalAssets++
+		summary.CountByState[asset.State.String()]++
+		if asset.isActive() {
+			summary.TotalPrincipal[asset.currency()] += asset.Amount
+			outstanding[asset.currency()]++
+		}
+	}
+
+	for currency, count := range outstanding {
+		summary.AveragePrincipal[currency] = float64(summary.TotalPrincipal[currency]) / float64(count)
+	}
+
+	return summary, nil
+}
+
+// CurrencyTotal is the outstanding principal and number of active loans in one currency
+type CurrencyTotal struct {
+	TotalOutstanding int `json:"totalOutstanding"`
+	Count            int `json:"count"`
+}
+
+// GetPortfolioByCurrency returns the total outstanding principal and the number of active assets, i.e.
+// those not redeemed or written off, per currency
+func (s *SmartContract) GetPortfolioByCurrency(ctx contractapi.TransactionContextInterface) (map[string]CurrencyTotal, error) {
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]CurrencyTotal)
+	for _, asset := range assets {
+		if !asset.isActive() {
+			continue
+		}
+
+		total := totals[asset.currency()]
+		total.TotalOutstanding += asset.Amount
+		total.Count++
+		totals[asset.currency()] = total
+	}
+
+	return totals, nil
+}
+
+// ValidateAssetTerms reports whether IssueAsset with the same arguments would pass validation for the
+// submitting client, without writing to the ledger. Collateral is read from the transient map as in
+// IssueAsset. Invalid terms return false together with the reason.
+func (s *SmartContract) ValidateAssetTerms(ctx contractapi.TransactionContextInterface, assetID string, amount int, start int, end int, region string, currency string) (bool, error) {
+
+	clientID, _, err := getClientOrgID(ctx, true)
+	if err != nil {
+		return false, fmt.Errorf("failed to get verified OrgID: %v", err)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return false, fmt.Errorf("error getting transient: %v", err)
+	}
+
+	asset := Asset{
+		ID:        assetID,
+		Amount:    amount,
+		StartDate: start,
+		EndDate:   end,
+		Region:    region,
+		Currency:  currency,
+	}
+
+	err = readTransientCollateral(transientMap, &asset)
+	if err != nil {
+		return false, err
+	}
+
+	err = validateNewAsset(ctx, &asset, clientID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// agreementTerms are the asset terms covered by the borrower's agreement signature
+type agreementTerms struct {
+	ID        string `json:"assetID"`
+	Lender    string `json:"lender"`
+	Borrower  string `json:"borrower"`
+	Amount    int    `json:"amount"`
+	StartDate int    `json:"startDate"`
+	EndDate   int    `json:"endDate"`
+}
+
+// VerifyAgreement checks the stored agreement signature of an asset against the hex encoded
+// PKIX public key of the borrower. It returns false if the signature does not match the terms.
+func (s *SmartContract) VerifyAgreement(ctx contractapi.TransactionContextInterface, assetID string, pubKeyHex string) (bool, error) {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return false, err
+	}
+	if len(asset.AgreementSignature) == 0 {
+		return false, fmt.Errorf("agreement for asset %v has not been signed", assetID)
+	}
+
+	digest, err := agreementDigest(asset)
+	if err != nil {
+		return false, err
+	}
+
+	return verifySignature(pubKeyHex, digest, asset.AgreementSignature)
+}
+
+// readAsset reads an asset from the world state, returning an error if it does not exist.
+func readAsset(ctx contractapi.TransactionContextInterface, assetID string) (*Asset, error) {
+	asset, err := getAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	if asset == nil {
+		return nil, fmt.Errorf("asset %v does not exist", assetID)
+	}
+
+	return asset, nil
+}
+
+// getAsset reads an asset from the world state, returning nil if it does not exist.
+func getAsset(ctx contractapi.TransactionContextInterface, assetID string) (*Asset, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{assetID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	assetJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if assetJSON == nil {
+		return nil, nil
+	}
+
+	return unmarshalAsset(assetJSON)
+}
+
+// unmarshalAsset decodes and validates an asset read from the ledger, defaulting a missing state.
+func unmarshalAsset(assetJSON []byte) (*Asset, error) {
+	asset, err := decodeAsset(assetJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	err = asset.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return asset, nil
+}
+
+// decodeAsset decodes an asset read from the ledger, defaulting a missing state, without validating
+// it. Scans over many assets use it so that one invalid legacy asset does not fail the whole scan.
+func decodeAsset(assetJSON []byte) (*Asset, error) {
+	var asset *Asset
+	err := json.Unmarshal(assetJSON, &asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	asset.defaultState()
+	return asset, nil
+}
+
+// getAllAssets returns every asset in the world state, ordered by asset ID.
+func getAllAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(typeAsset, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructQueryResponseFromIterator(resultsIterator)
+}
+
+// agreementDigest returns the SHA-256 digest of the canonical JSON of the asset terms.
+func agreementDigest(asset *Asset) ([]byte, error) {
+	terms := agreementTerms{
+		ID:        asset.ID,
+		Lender:    asset.Lender,
+		Borrower:  asset.Borrower,
+		Amount:    asset.Amount,
+		StartDate: asset.StartDate,
+		EndDate:   asset.EndDate,
+	}
+
+	termsJSON, err := json.Marshal(terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create terms JSON: %v", err)
+	}
+
+	digest := sha256.Sum256(termsJSON)
+	return digest[:], nil
+}
+
+// parsePublicKey decodes a hex encoded PKIX public key, which must be an ECDSA key on the P-256 curve.
+func parsePublicKey(pubKeyHex string) (*ecdsa.PublicKey, error) {
+	pubKeyBytes, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("public key must be a hex string: %v", err)
+	}
+
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an ECDSA key")
+	}
+	if ecdsaPubKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("public key is on curve %v, only P-256 keys are supported", ecdsaPubKey.Curve.Params().Name)
+	}
+
+	return ecdsaPubKey, nil
+}
+
+// verifySignature checks a hex encoded ASN.1 ECDSA signature over digest using a hex encoded
+// PKIX P-256 public key.
+func verifySignature(pubKeyHex string, digest []byte, signatureHex string) (bool, error) {
+	ecdsaPubKey, err := parsePublicKey(pubKeyHex)
+	if err != nil {
+		return false, err
+	}
+
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return false, fmt.Errorf("signature must be a hex string: %v", err)
+	}
+
+	return ecdsa.VerifyASN1(ecdsaPubKey, digest, signature), nil
+}
+
+// assetRevision is a single modification of an asset taken from its history
+type assetRevision struct {
+	txID      string
+	timestamp time.Time
+	asset     *Asset
+	isDelete  bool
+}
+
+// stateTransition records when an asset entered a state
+type stateTransition struct {
+	state     State
+	timestamp time.Time
+}
+
+// getAssetRevisions returns the history of an asset in chronological order.
+func getAssetRevisions(ctx contractapi.TransactionContextInterface, assetID string) ([]assetRevision, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{assetID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of asset %v: %v", assetID, err)
+	}
+	defer resultsIterator.Close()
+
+	var revisions []assetRevision
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		timestamp, err := ptypes.Timestamp(response.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		revision := assetRevision{
+			txID:      response.TxId,
+			timestamp: timestamp,
+			isDelete:  response.IsDelete,
+		}
+		if !response.IsDelete {
+			err = json.Unmarshal(response.Value, &revision.asset)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+			}
+			revision.asset.defaultState()
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	// The history iterator does not guarantee an ordering, so order the revisions oldest first
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].timestamp.Before(revisions[j].timestamp)
+	})
+
+	return revisions, nil
+}
+
+// getStateTransitions returns every state change of an asset in chronological order.
+// The creation of the asset counts as entering its initial state.
+func getStateTransitions(ctx contractapi.TransactionContextInterface, assetID string) ([]stateTransition, error) {
+	revisions, err := getAssetRevisions(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var transitions []stateTransition
+	var current State
+	for _, revision := range revisions {
+		if revision.isDelete {
+			current = 0
+			continue
+		}
+		if revision.asset.State == current {
+			continue
+		}
+
+		current = revision.asset.State
+		transitions = append(transitions, stateTransition{
+			state:     current,
+			timestamp: revision.timestamp,
+		})
+	}
+
+	return transitions, nil
+}
+
+// lastEnteredState returns the time an asset most recently entered the given state.
+func lastEnteredState(transitions []stateTransition, state State) (time.Time, bool) {
+	for i := len(transitions) - 1; i >= 0; i-- {
+		if transitions[i].state == state {
+			return transitions[i].timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// loanTermsView returns a copy of the asset holding only the terms of the loan.
+func loanTermsView(asset *Asset) *Asset {
+	return &Asset{
+		Type:             asset.Type,
+		ID:               asset.ID,
+		State:            asset.State,
+		Amount:           asset.Amount,
+		StartDate:        asset.StartDate,
+		EndDate:          asset.EndDate,
+		Currency:         asset.Currency,
+		Rating:           asset.Rating,
+		Region:           asset.Region,
+		IssuerMSP:        asset.IssuerMSP,
+		Restructured:     asset.Restructured,
+		RestructureCount: asset.RestructureCount,
+	}
+}
+
+// convertAmount scales amount by rateNum/rateDen, rounding down, and fails instead of overflowing
+func convertAmount(amount int64, rateNum int64, rateDen int64) (int64, error) {
+	if amount < 0 {
+		return 0, fmt.Errorf("amount %d is negative", amount)
+	}
+	if amount > math.MaxInt64/rateNum {
+		return 0, fmt.Errorf("amount %d is too large to convert", amount)
+	}
+
+	return amount * rateNum / rateDen, nil
+}
+
+// borrowerView returns a copy of the asset holding the loan terms, the parties and the repayment
+// details of the borrower, leaving out the investor side of the loan.
+func borrowerView(asset *Asset) *Asset {
+	view := loanTermsView(asset)
+	view.Lender = asset.Lender
+	view.Borrower = asset.Borrower
+	view.BorrowerAddress = asset.BorrowerAddress
+	view.PaymentHashes = asset.PaymentHashes
+	view.AgreementSignature = asset.AgreementSignature
+	view.Collateral = asset.Collateral
+	view.SettlementRef = asset.SettlementRef
+	view.RedemptionHash = asset.RedemptionHash
+	view.CancelReason = asset.CancelReason
+
+	return view
+}
+
+// recommendedAction returns the next servicing action for an asset at currentDate.
+func recommendedAction(asset *Asset, currentDate int) string {
+	switch asset.State {
+	case ISSUED:
+		return actionDisburse
+	case PENDING:
+		return actionBeginTrading
+	case TRADING:
+		if currentDate < asset.EndDate {
+			return actionRecordPayment
+		}
+		if len(asset.PaymentHashes) == 0 {
+			return actionMarkOverdue
+		}
+		return actionRedeem
+	default:
+		return actionNone
+	}
+}
+
+// activeAssets returns the assets that are still outstanding.
+func activeAssets(assets []*Asset) []*Asset {
+	active := []*Asset{}
+	for _, asset := range assets {
+		if asset.isActive() {
+			active = append(active, asset)
+		}
+	}
+	return active
+}
+
+// verifySingleCurrency checks that the loans are all in the same currency, so their amounts can be added up.
+func verifySingleCurrency(assets []*Asset) error {
+	seen := make(map[string]bool)
+	currencies := []string{}
+	for _, asset := range assets {
+		if !seen[asset.currency()] {
+			seen[asset.currency()] = true
+			currencies = append(currencies, asset.currency())
+		}
+	}
+	if len(currencies) <= 1 {
+		return nil
+	}
+
+	sort.Strings(currencies)
+	return fmt.Errorf("loans are in more than one currency: %v", strings.Join(currencies, ", "))
+}
+
+// loanToValue returns the amount of the asset divided by the valuation of its collateral.
+func loanToValue(asset *Asset) (float64, error) {
+	if !asset.hasCollateral() || asset.Collateral.ValuationAmount == 0 {
+		return 0, fmt.Errorf("asset %v has no valued collateral", asset.ID)
+	}
+
+	return float64(asset.Amount) / float64(asset.Collateral.ValuationAmount), nil
+}
+
+// getQueryResultForSelector runs a CouchDB rich query for loan assets matching the selector fields.
+func getQueryResultForSelector(ctx contractapi.TransactionContextInterface, fields map[string]interface{}) ([]*Asset, error) {
+	query, err := selectorQuery(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(query)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructQueryResponseFromIterator(resultsIterator)
+}
+
+// constructQueryResponseFromIterator unmarshals every asset returned by a state query iterator.
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]*Asset, error) {
+	results := []*Asset{}
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := decodeAsset(response.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, asset)
+	}
+
+	return results, nil
+}
+
+// isPartyOf reports whether the identity is the lender or borrower of the asset.
+func isPartyOf(asset *Asset, identity string) bool {
+	if identity == normalizeIdentity(asset.Lender) {
+		return true
+	}
+	return len(asset.Borrower) != 0 && identity == normalizeIdentity(asset.Borrower)
+}
+
+// selectorQuery builds a CouchDB query string selecting loan assets that match the selector fields.
+func selectorQuery(fields map[string]interface{}) (string, error) {
+	selector := map[string]interface{}{"objectType": loanAssetType}
+	for field, value := range fields {
+		selector[field] = value
+	}
+
+	queryBytes, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", fmt.Errorf("failed to create query JSON: %v", err)
+	}
+
+	return string(queryBytes), nil
+}
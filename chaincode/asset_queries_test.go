@@ -0,0 +1,1527 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	asset, err := ledger.contract.ReadAsset(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, ledger.getTestAsset("loan1"), asset)
+
+	_, err = ledger.contract.ReadAsset(ledger.tx(testOutsider), "missing")
+	require.EqualError(t, err, "asset missing does not exist")
+}
+
+func TestTryReadAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	asset, err := ledger.contract.TryReadAsset(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, ledger.getTestAsset("loan1"), asset)
+
+	asset, err = ledger.contract.TryReadAsset(ledger.tx(testOutsider), "missing")
+	require.NoError(t, err)
+	require.Nil(t, asset)
+
+	ledger.stub.stateErr = fmt.Errorf("state database is unavailable")
+	_, err = ledger.contract.TryReadAsset(ledger.tx(testOutsider), "loan1")
+	require.EqualError(t, err, "failed to read from world state: state database is unavailable")
+}
+
+func TestQueryAssetsOnlyReturnsLoanAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+	large := newTestAsset("loan2", ISSUED)
+	large.Amount = 5000
+	ledger.putTestAsset(large)
+
+	// a restructure proposal shares the amount field with assets but is not a loan asset
+	ledger.stub.startTx()
+	proposalKey, err := ledger.stub.CreateCompositeKey(restructureObjectType, []string{"loan1"})
+	require.NoError(t, err)
+	require.NoError(t, ledger.stub.PutState(proposalKey, []byte(`{"amount":5000,"startDate":20210101,"endDate":20221231}`)))
+
+	assets, err := ledger.contract.QueryAssets(ledger.tx(testOutsider), `{"selector":{"amount":{"$gte":2000}}}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan2"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssets(ledger.tx(testOutsider), `{"selector":{"objectType":"restructure"}}`)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+}
+
+func TestQueryAssetsRejectsMalformedQueries(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	_, err := ledger.contract.QueryAssets(ledger.tx(testOutsider), `{"selector":`)
+	require.Error(t, err)
+
+	_, err = ledger.contract.QueryAssets(ledger.tx(testOutsider), `{"amount":1000}`)
+	require.EqualError(t, err, "query must contain a selector object")
+
+	_, err = ledger.contract.QueryAssets(ledger.tx(testOutsider), `{"selector":{"amount":1000}}`+string(make([]byte, maxQuerySelectorSize)))
+	require.EqualError(t, err, "query selector exceeds the maximum size of 4096 bytes")
+}
+
+func TestGetAssetsEnteredStateAfter(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset := newTestAsset("loan1", ISSUED)
+	ledger.putTestAsset(asset)
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+
+	ledger.advance(time.Hour)
+	asset.State = PENDING
+	asset.Borrower = testBorrower.id()
+	ledger.putTestAsset(asset)
+
+	assets, err := ledger.contract.GetAssetsEnteredStateAfter(ledger.tx(testOutsider), "PENDING", testStart.Unix())
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.GetAssetsEnteredStateAfter(ledger.tx(testOutsider), "PENDING", testStart.Add(time.Hour).Unix())
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	assets, err = ledger.contract.GetAssetsEnteredStateAfter(ledger.tx(testOutsider), "ISSUED", testStart.Unix()-1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan2"}, testAssetIDs(assets))
+
+	_, err = ledger.contract.GetAssetsEnteredStateAfter(ledger.tx(testOutsider), "OPEN", 0)
+	require.EqualError(t, err, `unknown state "OPEN"`)
+}
+
+func TestGetTradeableAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	ledger.putTestAsset(newTestAsset("pending", PENDING))
+	ledger.putTestAsset(newTestAsset("trading", TRADING))
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	matured := newTestAsset("matured", TRADING)
+	matured.EndDate = 20210601
+	ledger.putTestAsset(matured)
+
+	overdue := newTestAsset("overdue", PENDING)
+	overdue.EndDate = 20210301
+	ledger.putTestAsset(overdue)
+
+	assets, err := ledger.contract.GetTradeableAssets(ledger.tx(testInvestor), 20210601)
+	require.NoError(t, err)
+	require.Equal(t, []string{"pending", "trading"}, testAssetIDs(assets))
+
+	_, err = ledger.contract.GetTradeableAssets(ledger.tx(testInvestor), 20211301)
+	require.EqualError(t, err, "date 20211301 is not a valid YYYYMMDD date")
+}
+
+func TestComputeLedgerHash(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+	ledger.putTestAsset(newTestAsset("loan2", PENDING))
+
+	hash, err := ledger.contract.ComputeLedgerHash(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Len(t, hash, 64)
+
+	again, err := ledger.contract.ComputeLedgerHash(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, hash, again)
+
+	asset := newTestAsset("loan2", PENDING)
+	asset.Amount++
+	ledger.putTestAsset(asset)
+
+	changed, err := ledger.contract.ComputeLedgerHash(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.NotEqual(t, hash, changed)
+}
+
+func TestReadAssetForCallerRedactsByCaller(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset := newTestAsset("loan1", TRADING)
+	asset.BorrowerAddress = "borrower-wallet"
+	asset.InvestorAddress = "investor-wallet"
+	asset.OwnerAddress = "owner-wallet"
+	asset.PaymentHashes = []string{"3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"}
+	asset.InvestorPubKey = "3059"
+	asset.AgreementSignature = "3045"
+	asset.PreviousLenders = []LenderChange{{Lender: testInvestor.id(), AuthorizedBy: testInvestor.id(), Timestamp: testStart}}
+	asset.Collateral = Collateral{Description: "warehouse", ValuationAmount: 2000, ValuationDate: 20210101}
+	asset.SettlementRef = "settlement"
+	asset.RedemptionHash = "redemption"
+	asset.LastModifiedBy = testLender.id()
+	asset.Rating = "AA"
+	asset.Version = 3
+	ledger.putTestAsset(asset)
+
+	view, err := ledger.contract.ReadAssetForCaller(ledger.tx(testLender), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, &asset, view)
+
+	view, err = ledger.contract.ReadAssetForCaller(ledger.tx(testBorrower), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, &Asset{
+		Type:               loanAssetType,
+		ID:                 "loan1",
+		Lender:             testLender.id(),
+		Borrower:           testBorrower.id(),
+		State:              TRADING,
+		Amount:             1000,
+		StartDate:          20210101,
+		EndDate:            20211231,
+		BorrowerAddress:    "borrower-wallet",
+		PaymentHashes:      asset.PaymentHashes,
+		AgreementSignature: "3045",
+		Rating:             "AA",
+		IssuerMSP:          testMSPID,
+		Currency:           defaultCurrency,
+		Collateral:         asset.Collateral,
+		SettlementRef:      "settlement",
+		RedemptionHash:     "redemption",
+	}, view)
+
+	// the borrower address kept in the private data collection takes precedence over a legacy public one
+	addresses := AssetPrivateAddresses{BorrowerAddress: "private-borrower-wallet", InvestorAddress: "private-investor-wallet"}
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, map[string]interface{}{"asset_addresses": addresses}), "loan1"))
+	view, err = ledger.contract.ReadAssetForCaller(ledger.tx(testBorrower), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, "private-borrower-wallet", view.BorrowerAddress)
+	require.Empty(t, view.InvestorAddress)
+
+	view, err = ledger.contract.ReadAssetForCaller(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, &Asset{
+		Type:      loanAssetType,
+		ID:        "loan1",
+		State:     TRADING,
+		Amount:    1000,
+		StartDate: 20210101,
+		EndDate:   20211231,
+		Rating:    "AA",
+		IssuerMSP: testMSPID,
+		Currency:  defaultCurrency,
+	}, view)
+}
+
+func TestReadAssetInCurrency(t *testing.T) {
+	ledger := newTestLedger(t)
+	euroLoan := newTestAsset("loan1", TRADING)
+	euroLoan.Currency = "EUR"
+	euroLoan.Amount = 1001
+	euroLoan.Collateral = Collateral{Description: "warehouse", ValuationAmount: 2000, ValuationDate: 20210101}
+	ledger.putTestAsset(euroLoan)
+
+	// 1 EUR = 1.08 USD
+	converted, err := ledger.contract.ReadAssetInCurrency(ledger.tx(testOutsider), "loan1", "USD", 108, 100)
+	require.NoError(t, err)
+	require.Equal(t, "USD", converted.Currency)
+	require.Equal(t, 1081, converted.Amount)
+	require.Equal(t, int64(2160), converted.Collateral.ValuationAmount)
+	require.Equal(t, "loan1", converted.ID)
+
+	stored := ledger.getTestAsset("loan1")
+	require.Equal(t, "EUR", stored.Currency)
+	require.Equal(t, 1001, stored.Amount)
+
+	for _, rate := range [][2]int64{{0, 100}, {108, 0}, {-108, 100}} {
+		_, err = ledger.contract.ReadAssetInCurrency(ledger.tx(testOutsider), "loan1", "USD", rate[0], rate[1])
+		require.EqualError(t, err, "exchange rate numerator and denominator must be positive integers", "rate %v", rate)
+	}
+
+	_, err = ledger.contract.ReadAssetInCurrency(ledger.tx(testOutsider), "loan1", "XYZ", 108, 100)
+	require.EqualError(t, err, `currency "XYZ" is not supported`)
+
+	_, err = ledger.contract.ReadAssetInCurrency(ledger.tx(testOutsider), "loan1", "", 108, 100)
+	require.EqualError(t, err, "target currency must be a non-empty string")
+
+	_, err = ledger.contract.ReadAssetInCurrency(ledger.tx(testOutsider), "missing", "USD", 108, 100)
+	require.EqualError(t, err, "asset missing does not exist")
+
+	_, err = ledger.contract.ReadAssetInCurrency(ledger.tx(testOutsider), "loan1", "USD", math.MaxInt64, 1)
+	require.EqualError(t, err, "failed to convert amount of asset loan1: amount 1001 is too large to convert")
+}
+
+func TestCountDistinctBorrowers(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	ledger.putTestAsset(newTestAsset("loan2", TRADING))
+	again := newTestAsset("loan3", PENDING)
+	again.Borrower = " x509:: cn=borrower, ou=client::CN=ca.org1.example.com,O=org1.example.com"
+	ledger.putTestAsset(again)
+	other := newTestAsset("loan4", TRADING)
+	other.Borrower = testInvestor.id()
+	ledger.putTestAsset(other)
+	ledger.putTestAsset(newTestAsset("unassigned", ISSUED))
+	redeemed := newTestAsset("redeemed", REDEEMED)
+	redeemed.Borrower = testOutsider.id()
+	ledger.putTestAsset(redeemed)
+
+	count, err := ledger.contract.CountDistinctBorrowers(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
+func TestDetectRapidTransitions(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	fast := newTestAsset("fast", ISSUED)
+	normal := newTestAsset("normal", ISSUED)
+	ledger.putTestAsset(fast)
+	ledger.putTestAsset(normal)
+
+	ledger.advance(10 * time.Second)
+	fast.State = PENDING
+	fast.Borrower = testBorrower.id()
+	ledger.putTestAsset(fast)
+	ledger.advance(10 * time.Second)
+	fast.State = TRADING
+	ledger.putTestAsset(fast)
+
+	ledger.advance(24 * time.Hour)
+	normal.State = PENDING
+	normal.Borrower = testBorrower.id()
+	ledger.putTestAsset(normal)
+	ledger.advance(24 * time.Hour)
+	normal.State = TRADING
+	ledger.putTestAsset(normal)
+
+	assets, err := ledger.contract.DetectRapidTransitions(ledger.tx(testOutsider), 60)
+	require.NoError(t, err)
+	require.Equal(t, []string{"fast"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.DetectRapidTransitions(ledger.tx(testOutsider), 3*24*60*60)
+	require.NoError(t, err)
+	require.Equal(t, []string{"fast", "normal"}, testAssetIDs(assets))
+
+	_, err = ledger.contract.DetectRapidTransitions(ledger.tx(testOutsider), 0)
+	require.EqualError(t, err, "maxSeconds must be a positive integer")
+}
+
+func TestGetAssetsByStateMaturingBefore(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	early := newTestAsset("early", TRADING)
+	early.EndDate = 20210630
+	ledger.putTestAsset(early)
+	ledger.putTestAsset(newTestAsset("late", TRADING))
+	earlyPending := newTestAsset("earlyPending", PENDING)
+	earlyPending.EndDate = 20210630
+	ledger.putTestAsset(earlyPending)
+
+	assets, err := ledger.contract.GetAssetsByStateMaturingBefore(ledger.tx(testOutsider), "TRADING", 20210701)
+	require.NoError(t, err)
+	require.Equal(t, []string{"early"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.GetAssetsByStateMaturingBefore(ledger.tx(testOutsider), "TRADING", 20210630)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.GetAssetsByStateMaturingBefore(ledger.tx(testOutsider), "trading", 20210701)
+	require.EqualError(t, err, `unknown state "trading"`)
+}
+
+func TestGetRecommendedAction(t *testing.T) {
+	paid := newTestAsset("paid", TRADING)
+	paid.PaymentHashes = []string{"3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"}
+
+	tests := []struct {
+		asset       Asset
+		currentDate int
+		expected    string
+	}{
+		{newTestAsset("issued", ISSUED), 20210601, actionDisburse},
+		{newTestAsset("pending", PENDING), 20210601, actionBeginTrading},
+		{newTestAsset("trading", TRADING), 20210601, actionRecordPayment},
+		{newTestAsset("unpaid", TRADING), 20211231, actionMarkOverdue},
+		{paid, 20211231, actionRedeem},
+		{newTestAsset("redeemed", REDEEMED), 20220101, actionNone},
+	}
+
+	ledger := newTestLedger(t)
+	for _, test := range tests {
+		ledger.putTestAsset(test.asset)
+
+		action, err := ledger.contract.GetRecommendedAction(ledger.tx(testLender), test.asset.ID, test.currentDate)
+		require.NoError(t, err)
+		require.Equal(t, test.expected, action, "asset %v", test.asset.ID)
+	}
+
+	_, err := ledger.contract.GetRecommendedAction(ledger.tx(testLender), "missing", 20210601)
+	require.EqualError(t, err, "asset missing does not exist")
+}
+
+func TestDaysInCurrentState(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset := newTestAsset("loan1", ISSUED)
+	ledger.putTestAsset(asset)
+	ledger.advance(3 * 24 * time.Hour)
+	asset.State = PENDING
+	asset.Borrower = testBorrower.id()
+	ledger.putTestAsset(asset)
+	ledger.advance(24 * time.Hour)
+	asset.Amount = 2000
+	ledger.putTestAsset(asset)
+
+	days, err := ledger.contract.DaysInCurrentState(ledger.tx(testOutsider), "loan1", 20210614)
+	require.NoError(t, err)
+	require.Equal(t, 10, days)
+
+	_, err = ledger.contract.DaysInCurrentState(ledger.tx(testOutsider), "loan1", 20210603)
+	require.EqualError(t, err, "current date 20210603 is before asset loan1 entered state PENDING")
+
+	_, err = ledger.contract.DaysInCurrentState(ledger.tx(testOutsider), "loan1", 20210632)
+	require.EqualError(t, err, "date 20210632 is not a valid YYYYMMDD date")
+}
+
+func TestGetChangeFeed(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	loan1 := newTestAsset("loan1", ISSUED)
+	ledger.putTestAsset(loan1)
+	ledger.advance(time.Minute)
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+	ledger.advance(time.Minute)
+	loan1.Amount = 2000
+	ledger.putTestAsset(loan1)
+	ledger.advance(time.Minute)
+	require.NoError(t, ledger.contract.DeleteAsset(ledger.tx(testLender), "loan2"))
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+
+	page, err := ledger.contract.GetChangeFeed(ledger.tx(testOutsider), testStart.Unix(), 2, "")
+	require.NoError(t, err)
+	require.Equal(t, []ChangeEvent{
+		{AssetID: "loan2", TxID: "tx2", ChangeType: changeCreate, Timestamp: testStart.Add(time.Minute)},
+		{AssetID: "loan1", TxID: "tx3", ChangeType: changeUpdate, Timestamp: testStart.Add(2 * time.Minute)},
+	}, page.Events)
+	require.Equal(t, "2", page.Bookmark)
+
+	page, err = ledger.contract.GetChangeFeed(ledger.tx(testOutsider), testStart.Unix(), 2, page.Bookmark)
+	require.NoError(t, err)
+	require.Equal(t, []ChangeEvent{
+		{AssetID: "loan2", TxID: "tx4", ChangeType: changeDelete, Timestamp: testStart.Add(3 * time.Minute)},
+		{AssetID: "loan2", TxID: "tx5", ChangeType: changeCreate, Timestamp: testStart.Add(3 * time.Minute)},
+	}, page.Events)
+	require.Empty(t, page.Bookmark)
+
+	_, err = ledger.contract.GetChangeFeed(ledger.tx(testOutsider), 0, 0, "")
+	require.EqualError(t, err, "page size must be a positive integer")
+
+	_, err = ledger.contract.GetChangeFeed(ledger.tx(testOutsider), 0, 2, "next")
+	require.EqualError(t, err, `invalid bookmark "next"`)
+}
+
+func TestBuildLoanJourney(t *testing.T) {
+	ledger := newTestLedger(t)
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testBorrower.id()))
+
+	_, err := ledger.issueTestAsset("loan1", 1000, 20210101, 20210630)
+	require.NoError(t, err)
+	created := ledger.stub.now
+
+	journey, err := ledger.contract.BuildLoanJourney(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, outcomeOpen, journey.Outcome)
+	require.True(t, journey.DisbursedAt.IsZero())
+
+	ledger.advance(time.Hour)
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "borrower-wallet"))
+	disbursed := ledger.stub.now
+	ledger.advance(time.Hour)
+	require.NoError(t, ledger.contract.BeginTrading(ledger.tx(testLender), "loan1"))
+	ledger.advance(time.Hour)
+	_, err = ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", "USD")
+	require.NoError(t, err)
+	ledger.advance(30 * 24 * time.Hour)
+	require.NoError(t, ledger.contract.RedeemAsset(ledger.tx(testLender), "loan1", 0, "settlement"))
+
+	journey, err = ledger.contract.BuildLoanJourney(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, &LoanJourney{
+		AssetID:     "loan1",
+		CreatedAt:   created,
+		DisbursedAt: disbursed,
+		Transitions: []StateChange{
+			{State: "ISSUED", Timestamp: created},
+			{State: "PENDING", Timestamp: disbursed},
+			{State: "TRADING", Timestamp: disbursed.Add(time.Hour)},
+			{State: "REDEEMED", Timestamp: ledger.stub.now},
+		},
+		PaymentCount: 1,
+		Outcome:      outcomeRedeemed,
+	}, journey)
+}
+
+func TestGetTotalValueLocked(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	total, err := ledger.contract.GetTotalValueLocked(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Zero(t, total)
+
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	pending := newTestAsset("pending", PENDING)
+	pending.Amount = 2000
+	ledger.putTestAsset(pending)
+	trading := newTestAsset("trading", TRADING)
+	trading.Amount = 4000
+	ledger.putTestAsset(trading)
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+	ledger.putTestAsset(newTestAsset("writtenOff", WRITTEN_OFF))
+
+	total, err = ledger.contract.GetTotalValueLocked(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, int64(7000), total)
+
+	// inactive loans in another currency do not mix the book
+	redeemedEUR := newTestAsset("redeemedEUR", REDEEMED)
+	redeemedEUR.Currency = "EUR"
+	ledger.putTestAsset(redeemedEUR)
+	total, err = ledger.contract.GetTotalValueLocked(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, int64(7000), total)
+
+	tradingEUR := newTestAsset("tradingEUR", TRADING)
+	tradingEUR.Currency = "EUR"
+	ledger.putTestAsset(tradingEUR)
+	_, err = ledger.contract.GetTotalValueLocked(ledger.tx(testOutsider))
+	require.EqualError(t, err, "loans are in more than one currency: EUR, USD")
+}
+
+func TestValidateAllDates(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	ledger.putTestAsset(newTestAsset("valid", ISSUED))
+	badEnd := newTestAsset("badEnd", ISSUED)
+	badEnd.EndDate = 20211301
+	ledger.putTestAsset(badEnd)
+	badStart := newTestAsset("badStart", TRADING)
+	badStart.StartDate = 20210230
+	ledger.putTestAsset(badStart)
+	sameDay := newTestAsset("sameDay", PENDING)
+	sameDay.EndDate = sameDay.StartDate
+	ledger.putTestAsset(sameDay)
+	endBeforeStart := newTestAsset("endBeforeStart", TRADING)
+	endBeforeStart.EndDate = 20201231
+	ledger.putTestAsset(endBeforeStart)
+
+	invalid, err := ledger.contract.ValidateAllDates(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"badEnd", "badStart", "endBeforeStart", "sameDay"}, invalid)
+}
+
+func TestGetStatesVisited(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset := newTestAsset("loan1", ISSUED)
+	ledger.putTestAsset(asset)
+	ledger.advance(time.Hour)
+	asset.State = PENDING
+	asset.Borrower = testBorrower.id()
+	ledger.putTestAsset(asset)
+	ledger.advance(time.Hour)
+	asset.State = ISSUED
+	asset.Borrower = ""
+	ledger.putTestAsset(asset)
+	ledger.advance(time.Hour)
+	asset.State = PENDING
+	asset.Borrower = testBorrower.id()
+	ledger.putTestAsset(asset)
+	ledger.advance(time.Hour)
+	asset.State = TRADING
+	ledger.putTestAsset(asset)
+
+	states, err := ledger.contract.GetStatesVisited(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ISSUED", "PENDING", "TRADING"}, states)
+
+	_, err = ledger.contract.GetStatesVisited(ledger.tx(testOutsider), "missing")
+	require.EqualError(t, err, "asset missing has no history")
+}
+
+func TestGetStalePendingAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	ledger.putTestAsset(newTestAsset("stale", PENDING))
+	ledger.putTestAsset(newTestAsset("trading", TRADING))
+	ledger.advance(10 * 24 * time.Hour)
+	ledger.putTestAsset(newTestAsset("fresh", PENDING))
+
+	assets, err := ledger.contract.GetStalePendingAssets(ledger.tx(testOutsider), 20210615, 7)
+	require.NoError(t, err)
+	require.Equal(t, []string{"stale"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.GetStalePendingAssets(ledger.tx(testOutsider), 20210615, 14)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.GetStalePendingAssets(ledger.tx(testOutsider), 20210615, -1)
+	require.EqualError(t, err, "maxPendingDays must be zero or a positive integer")
+}
+
+func TestMedianLoanAmount(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	median, err := ledger.contract.MedianLoanAmount(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Zero(t, median)
+
+	for i, amount := range []int{3000, 1000, 7000} {
+		asset := newTestAsset(fmt.Sprintf("loan%d", i), TRADING)
+		asset.Amount = amount
+		ledger.putTestAsset(asset)
+	}
+	redeemed := newTestAsset("redeemed", REDEEMED)
+	redeemed.Amount = 100
+	ledger.putTestAsset(redeemed)
+
+	median, err = ledger.contract.MedianLoanAmount(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, int64(3000), median)
+
+	even := newTestAsset("even", PENDING)
+	even.Amount = 4001
+	ledger.putTestAsset(even)
+
+	median, err = ledger.contract.MedianLoanAmount(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, int64(3500), median)
+
+	pound := newTestAsset("pound", TRADING)
+	pound.Currency = "GBP"
+	ledger.putTestAsset(pound)
+	_, err = ledger.contract.MedianLoanAmount(ledger.tx(testOutsider))
+	require.EqualError(t, err, "loans are in more than one currency: GBP, USD")
+}
+
+func TestGetRestructuredAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestAsset(newTestAsset("loan2", TRADING))
+
+	for _, amount := range []int{900, 800} {
+		terms := fmt.Sprintf(`{"amount":%d,"startDate":20210101,"endDate":20221231}`, amount)
+		require.NoError(t, ledger.contract.ProposeRestructure(ledger.tx(testLender), "loan1", terms))
+		require.NoError(t, ledger.contract.ApplyRestructure(ledger.tx(testAdmin), "loan1"))
+	}
+
+	asset := ledger.getTestAsset("loan1")
+	require.True(t, asset.Restructured)
+	require.Equal(t, 2, asset.RestructureCount)
+	require.Equal(t, 800, asset.Amount)
+	require.False(t, ledger.getTestAsset("loan2").Restructured)
+
+	assets, err := ledger.contract.GetRestructuredAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1"}, testAssetIDs(assets))
+}
+
+func TestComputeLTV(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	secured := newTestAsset("secured", TRADING)
+	secured.Collateral = Collateral{Description: "warehouse", ValuationAmount: 4000, ValuationDate: 20210101}
+	ledger.putTestAsset(secured)
+	ledger.putTestAsset(newTestAsset("unsecured", TRADING))
+
+	ltv, err := ledger.contract.ComputeLTV(ledger.tx(testOutsider), "secured")
+	require.NoError(t, err)
+	require.Equal(t, 0.25, ltv)
+
+	_, err = ledger.contract.ComputeLTV(ledger.tx(testOutsider), "unsecured")
+	require.EqualError(t, err, "asset unsecured has no valued collateral")
+}
+
+func TestQueryAssetsByState(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	ledger.putTestAsset(newTestAsset("trading1", TRADING))
+	ledger.putTestAsset(newTestAsset("trading2", TRADING))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	assets, err := ledger.contract.QueryAssetsByState(ledger.tx(testOutsider), "TRADING")
+	require.NoError(t, err)
+	require.Equal(t, []string{"trading1", "trading2"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssetsByState(ledger.tx(testOutsider), "PENDING")
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.QueryAssetsByState(ledger.tx(testOutsider), "UNKNOWN")
+	require.EqualError(t, err, `unknown state "UNKNOWN"`)
+}
+
+func TestQueryAssetsByStates(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	ledger.putTestAsset(newTestAsset("pending", PENDING))
+	ledger.putTestAsset(newTestAsset("trading", TRADING))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	assets, err := ledger.contract.QueryAssetsByStates(ledger.tx(testOutsider), []string{"PENDING", "TRADING"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"pending", "trading"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssetsByStates(ledger.tx(testOutsider), []string{"WRITTEN_OFF", "CANCELLED"})
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.QueryAssetsByStates(ledger.tx(testOutsider), nil)
+	require.EqualError(t, err, "at least one state must be given")
+
+	_, err = ledger.contract.QueryAssetsByStates(ledger.tx(testOutsider), []string{"PENDING", "UNKNOWN"})
+	require.EqualError(t, err, `unknown state "UNKNOWN"`)
+}
+
+func TestQueryAssetsByStateWithPagination(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestAsset(newTestAsset("loan2", PENDING))
+	ledger.putTestAsset(newTestAsset("loan3", TRADING))
+	ledger.putTestAsset(newTestAsset("loan4", TRADING))
+
+	page, err := ledger.contract.QueryAssetsByStateWithPagination(ledger.tx(testOutsider), "TRADING", 2, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan3"}, testAssetIDs(page.Assets))
+	require.Equal(t, int32(2), page.FetchedRecordsCount)
+	require.NotEmpty(t, page.Bookmark)
+
+	page, err = ledger.contract.QueryAssetsByStateWithPagination(ledger.tx(testOutsider), "TRADING", 2, page.Bookmark)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan4"}, testAssetIDs(page.Assets))
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Empty(t, page.Bookmark)
+
+	_, err = ledger.contract.QueryAssetsByStateWithPagination(ledger.tx(testOutsider), "UNKNOWN", 2, "")
+	require.EqualError(t, err, `unknown state "UNKNOWN"`)
+	_, err = ledger.contract.QueryAssetsByStateWithPagination(ledger.tx(testOutsider), "TRADING", 0, "")
+	require.EqualError(t, err, "pageSize must be a positive number")
+}
+
+func TestQueryAssetsByLenderAndBorrower(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	other := newTestAsset("loan2", TRADING)
+	other.Lender = testInvestor.id()
+	other.Borrower = testOutsider.id()
+	ledger.putTestAsset(other)
+	ledger.putTestAsset(newTestAsset("loan3", ISSUED))
+
+	assets, err := ledger.contract.QueryAssetsByLender(ledger.tx(testOutsider), " "+testLender.id())
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan3"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssetsByBorrower(ledger.tx(testOutsider), testOutsider.id())
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan2"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssetsByBorrower(ledger.tx(testOutsider), testInvestor.id())
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.QueryAssetsByLender(ledger.tx(testOutsider), " ")
+	require.EqualError(t, err, "lender must be a non-empty string")
+	_, err = ledger.contract.QueryAssetsByBorrower(ledger.tx(testOutsider), "")
+	require.EqualError(t, err, "borrower must be a non-empty string")
+}
+
+func TestGetLTVBreaches(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	breaching := newTestAsset("breaching", TRADING)
+	breaching.Collateral = Collateral{Description: "car", ValuationAmount: 1000, ValuationDate: 20210101}
+	ledger.putTestAsset(breaching)
+	compliant := newTestAsset("compliant", TRADING)
+	compliant.Collateral = Collateral{Description: "warehouse", ValuationAmount: 2000, ValuationDate: 20210101}
+	ledger.putTestAsset(compliant)
+	ledger.putTestAsset(newTestAsset("unsecured", TRADING))
+
+	assets, err := ledger.contract.GetLTVBreaches(ledger.tx(testOutsider), 0.8)
+	require.NoError(t, err)
+	require.Equal(t, []string{"breaching"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.GetLTVBreaches(ledger.tx(testOutsider), 1)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.GetLTVBreaches(ledger.tx(testOutsider), 0)
+	require.EqualError(t, err, "maxLTV must be a positive number")
+}
+
+func TestGetAssetHistory(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset := newTestAsset("loan1", ISSUED)
+	ledger.putTestAsset(asset)
+	ledger.advance(time.Minute)
+	asset.Amount = 2000
+	ledger.putTestAsset(asset)
+	ledger.advance(time.Minute)
+	require.NoError(t, ledger.contract.DeleteAsset(ledger.tx(testLender), "loan1"))
+
+	history, err := ledger.contract.GetAssetHistory(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+
+	require.Equal(t, "tx1", history[0].TxID)
+	require.Equal(t, testStart, history[0].Timestamp)
+	require.Equal(t, 1000, history[0].Asset.Amount)
+	require.False(t, history[0].IsDelete)
+
+	require.Equal(t, "tx2", history[1].TxID)
+	require.Equal(t, 2000, history[1].Asset.Amount)
+
+	require.Equal(t, HistoryEntry{TxID: "tx3", Timestamp: testStart.Add(2 * time.Minute), IsDelete: true}, history[2])
+
+	history, err = ledger.contract.GetAssetHistory(ledger.tx(testOutsider), "missing")
+	require.NoError(t, err)
+	require.Empty(t, history)
+}
+
+func TestGetAllAssetsWithPagination(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, assetID := range []string{"loan1", "loan2", "loan3"} {
+		ledger.putTestAsset(newTestAsset(assetID, ISSUED))
+	}
+	ledger.putTestConfig(configMaxLoans, 10)
+
+	page, err := ledger.contract.GetAllAssetsWithPagination(ledger.tx(testOutsider), 2, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan2"}, testAssetIDs(page.Assets))
+	require.Equal(t, int32(2), page.FetchedRecordsCount)
+	require.NotEmpty(t, page.Bookmark)
+
+	page, err = ledger.contract.GetAllAssetsWithPagination(ledger.tx(testOutsider), 2, page.Bookmark)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan3"}, testAssetIDs(page.Assets))
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Empty(t, page.Bookmark)
+
+	_, err = ledger.contract.GetAllAssetsWithPagination(ledger.tx(testOutsider), 0, "")
+	require.EqualError(t, err, "pageSize must be a positive number")
+}
+
+func TestValidateAssetTermsMirrorsIssueAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("existing", TRADING))
+	ledger.putTestConfig(configRegions, []string{testRegion})
+	ledger.putTestConfig(configMinCoverage, 1.5)
+
+	collateral := Collateral{Description: "warehouse", ValuationAmount: 2000, ValuationDate: 20210101}
+	transient := func(assetID string, collateral Collateral) map[string]interface{} {
+		return map[string]interface{}{
+			assetID:      AssetPrivate{SecretMessage: "terms of " + assetID},
+			"collateral": collateral,
+		}
+	}
+	validate := func(assetID string, collateral Collateral, amount int, start int, end int, region string, currency string) (bool, error) {
+		return ledger.contract.ValidateAssetTerms(ledger.txWithTransient(testLender, transient(assetID, collateral)), assetID, amount, start, end, region, currency)
+	}
+	issue := func(assetID string, collateral Collateral, amount int, start int, end int, region string, currency string) error {
+		_, err := ledger.contract.IssueAsset(ledger.txWithTransient(testLender, transient(assetID, collateral)), assetID, amount, start, end, region, currency)
+		return err
+	}
+
+	tests := []struct {
+		assetID    string
+		collateral Collateral
+		amount     int
+		start      int
+		end        int
+		region     string
+		currency   string
+		expected   string
+	}{
+		{"existing", collateral, 1000, 20210101, 20211231, testRegion, "", "asset with id: existing already exist"},
+		{"loan1", collateral, 0, 20210101, 20211231, testRegion, "", "amount field must be a positive integer"},
+		{"loan1", collateral, 1000, 20211231, 20210101, testRegion, "", "end date 20210101 must be after start date 20211231"},
+		{"loan1", collateral, 1000, 20210101, 20211231, "US", "", `region "US" is not in the list of allowed regions`},
+		{"loan1", collateral, 1000, 20210101, 20211231, testRegion, "XYZ", `currency "XYZ" is not supported`},
+		{"loan1", Collateral{Description: "warehouse", ValuationAmount: 1000, ValuationDate: 20210101}, 1000, 20210101, 20211231, testRegion, "", "collateral coverage 1.00 of asset loan1 is below the minimum of 1.5"},
+		{"loan1", Collateral{ValuationAmount: 2000, ValuationDate: 20210101}, 1000, 20210101, 20211231, testRegion, "", "collateral description must be a non-empty string"},
+	}
+	for _, test := range tests {
+		valid, err := validate(test.assetID, test.collateral, test.amount, test.start, test.end, test.region, test.currency)
+		require.EqualError(t, err, test.expected)
+		require.False(t, valid)
+
+		err = issue(test.assetID, test.collateral, test.amount, test.start, test.end, test.region, test.currency)
+		require.EqualError(t, err, test.expected)
+	}
+
+	ledger.putTestConfig(configMaxLoans, 1)
+	_, err := validate("loan1", collateral, 1000, 20210101, 20211231, testRegion, "EUR")
+	require.EqualError(t, err, "lender already holds 1 active loans, the maximum is 1")
+	ledger.putTestConfig(configMaxLoans, 0)
+
+	ledger.putTestConfig(configBlackoutDates, []int{20210601})
+	_, err = validate("loan1", collateral, 1000, 20210101, 20211231, testRegion, "EUR")
+	require.EqualError(t, err, "assets cannot be issued on blackout date 20210601")
+	ledger.putTestConfig(configBlackoutDates, []int{})
+
+	valid, err := validate("loan1", collateral, 1000, 20210101, 20211231, testRegion, "EUR")
+	require.NoError(t, err)
+	require.True(t, valid)
+	exists, err := assetExists(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, issue("loan1", collateral, 1000, 20210101, 20211231, testRegion, "EUR"))
+	_, err = validate("loan1", collateral, 1000, 20210101, 20211231, testRegion, "EUR")
+	require.EqualError(t, err, "asset with id: loan1 already exist")
+}
+
+func TestGetArchivedAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", REDEEMED))
+	ledger.putTestAsset(newTestAsset("loan2", TRADING))
+	ledger.putTestAsset(newTestAsset("loan3", REDEEMED))
+
+	archived, err := ledger.contract.GetArchivedAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Empty(t, archived)
+
+	require.NoError(t, ledger.contract.ArchiveRedeemedAsset(ledger.tx(testLender), "loan1"))
+	require.NoError(t, ledger.contract.ArchiveRedeemedAsset(ledger.tx(testLender), "loan3"))
+
+	assets, err := getAllAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan2"}, testAssetIDs(assets))
+
+	archived, err = ledger.contract.GetArchivedAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan3"}, testAssetIDs(archived))
+
+	// an unarchived asset leaves the archive range again
+	require.NoError(t, ledger.contract.UnarchiveAsset(ledger.tx(testAdmin), "loan1"))
+
+	assets, err = getAllAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan2"}, testAssetIDs(assets))
+
+	archived, err = ledger.contract.GetArchivedAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan3"}, testAssetIDs(archived))
+}
+
+func TestGetConcentrationByBorrower(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestConfig(configConcentration, 0.5)
+
+	large := newTestAsset("large", TRADING)
+	large.Amount = 5000
+	ledger.putTestAsset(large)
+	ledger.putTestAsset(newTestAsset("small", PENDING))
+	other := newTestAsset("other", TRADING)
+	other.Borrower = testInvestor.id()
+	other.Amount = 4000
+	ledger.putTestAsset(other)
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+	// shares are computed within each currency
+	euro := newTestAsset("euro", TRADING)
+	euro.Currency = "EUR"
+	euro.Amount = 100000
+	ledger.putTestAsset(euro)
+	euroOther := newTestAsset("euroOther", TRADING)
+	euroOther.Currency = "EUR"
+	euroOther.Borrower = testInvestor.id()
+	euroOther.Amount = 300000
+	ledger.putTestAsset(euroOther)
+
+	concentration, err := ledger.contract.GetConcentrationByBorrower(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []BorrowerConcentration{
+		{Borrower: testInvestor.id(), Currency: "EUR", Outstanding: 300000, Share: 0.75, ExceedsLimit: true},
+		{Borrower: testBorrower.id(), Currency: "EUR", Outstanding: 100000, Share: 0.25, ExceedsLimit: false},
+		{Borrower: testBorrower.id(), Currency: "USD", Outstanding: 6000, Share: 0.6, ExceedsLimit: true},
+		{Borrower: testInvestor.id(), Currency: "USD", Outstanding: 4000, Share: 0.4, ExceedsLimit: false},
+	}, concentration)
+}
+
+func TestGetAssetsByDaysToMaturity(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	for assetID, endDate := range map[string]int{"overdue": 20210525, "soon": 20210610, "later": 20210801} {
+		asset := newTestAsset(assetID, TRADING)
+		asset.EndDate = endDate
+		ledger.putTestAsset(asset)
+	}
+	redeemed := newTestAsset("redeemed", REDEEMED)
+	redeemed.EndDate = 20210610
+	ledger.putTestAsset(redeemed)
+
+	assets, err := ledger.contract.GetAssetsByDaysToMaturity(ledger.tx(testOutsider), 20210601, 0, 30)
+	require.NoError(t, err)
+	require.Equal(t, []string{"soon"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.GetAssetsByDaysToMaturity(ledger.tx(testOutsider), 20210601, -30, -1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"overdue"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.GetAssetsByDaysToMaturity(ledger.tx(testOutsider), 20210601, -7, 61)
+	require.NoError(t, err)
+	require.Equal(t, []string{"later", "overdue", "soon"}, testAssetIDs(assets))
+
+	_, err = ledger.contract.GetAssetsByDaysToMaturity(ledger.tx(testOutsider), 20210601, 10, 5)
+	require.EqualError(t, err, "minDays 10 must not be greater than maxDays 5")
+}
+
+func TestCalculateRepayment(t *testing.T) {
+	ledger := newTestLedger(t)
+	asset := newTestAsset("loan1", TRADING)
+	asset.Amount = 10000
+	asset.EndDate = 20220101
+	ledger.putTestAsset(asset)
+
+	// 10000 * 5% * 365/365
+	repayment, err := ledger.contract.CalculateRepayment(ledger.tx(testOutsider), "loan1", 5)
+	require.NoError(t, err)
+	require.Equal(t, 10500, repayment)
+
+	// 10000 * 5% * 181/365 = 247.95, rounded to 248
+	asset.ID = "loan2"
+	asset.EndDate = 20210701
+	ledger.putTestAsset(asset)
+	repayment, err = ledger.contract.CalculateRepayment(ledger.tx(testOutsider), "loan2", 5)
+	require.NoError(t, err)
+	require.Equal(t, 10248, repayment)
+
+	repayment, err = ledger.contract.CalculateRepayment(ledger.tx(testOutsider), "loan1", 0)
+	require.NoError(t, err)
+	require.Equal(t, 10000, repayment)
+
+	_, err = ledger.contract.CalculateRepayment(ledger.tx(testOutsider), "loan1", -1)
+	require.EqualError(t, err, "annual interest rate must not be negative")
+
+	_, err = ledger.contract.CalculateRepayment(ledger.tx(testOutsider), "missing", 5)
+	require.EqualError(t, err, "asset missing does not exist")
+}
+
+func TestGetModifiableAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("lent", PENDING))
+	borrowed := newTestAsset("borrowed", TRADING)
+	borrowed.Lender = testInvestor.id()
+	ledger.putTestAsset(borrowed)
+	unrelated := newTestAsset("unrelated", ISSUED)
+	unrelated.Lender = testInvestor.id()
+	ledger.putTestAsset(unrelated)
+
+	tests := []struct {
+		identity *testIdentity
+		expected []string
+	}{
+		{testLender, []string{"lent"}},
+		{testBorrower, []string{"borrowed", "lent"}},
+		{testInvestor, []string{"borrowed", "unrelated"}},
+		{testOutsider, []string{}},
+		{testAdmin, []string{"borrowed", "lent", "unrelated"}},
+	}
+
+	for _, test := range tests {
+		assets, err := ledger.contract.GetModifiableAssets(ledger.tx(test.identity))
+		require.NoError(t, err)
+		require.Equal(t, test.expected, testAssetIDs(assets), "caller %v", test.identity.name)
+	}
+}
+
+func TestQueryAssetsByAmountRange(t *testing.T) {
+	ledger := newTestLedger(t)
+	for assetID, amount := range map[string]int{"tiny": 100, "large": 5000, "medium": 2500, "small": 1000, "huge": 9000} {
+		asset := newTestAsset(assetID, ISSUED)
+		asset.Amount = amount
+		ledger.putTestAsset(asset)
+	}
+
+	assets, err := ledger.contract.QueryAssetsByAmountRange(ledger.tx(testOutsider), 1000, 5000)
+	require.NoError(t, err)
+	require.Equal(t, []string{"small", "medium", "large"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssetsByAmountRange(ledger.tx(testOutsider), 9001, 20000)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.QueryAssetsByAmountRange(ledger.tx(testOutsider), 5000, 1000)
+	require.EqualError(t, err, "min 5000 must not be greater than max 1000")
+}
+
+func TestReadAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, assetID := range []string{"loan1", "loan2", "loan3"} {
+		ledger.putTestAsset(newTestAsset(assetID, ISSUED))
+	}
+
+	assets, err := ledger.contract.ReadAssets(ledger.tx(testOutsider), []string{"loan3", "loan1"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan3", "loan1"}, testAssetIDs(assets))
+
+	_, err = ledger.contract.ReadAssets(ledger.tx(testOutsider), []string{"missing1", "loan2", "missing2"})
+	require.EqualError(t, err, "assets do not exist: missing1, missing2")
+
+	assets, err = ledger.contract.ReadAssets(ledger.tx(testOutsider), []string{})
+	require.NoError(t, err)
+	require.Empty(t, assets)
+}
+
+func TestCountAssetsByState(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("issued1", ISSUED))
+	ledger.putTestAsset(newTestAsset("issued2", ISSUED))
+	ledger.putTestAsset(newTestAsset("pending", PENDING))
+	ledger.putTestAsset(newTestAsset("trading1", TRADING))
+	ledger.putTestAsset(newTestAsset("trading2", TRADING))
+	ledger.putTestAsset(newTestAsset("trading3", TRADING))
+
+	for state, expected := range map[string]int{"ISSUED": 2, "PENDING": 1, "TRADING": 3, "REDEEMED": 0} {
+		count, err := ledger.contract.CountAssetsByState(ledger.tx(testOutsider), state)
+		require.NoError(t, err)
+		require.Equal(t, expected, count, "state %v", state)
+	}
+
+	_, err := ledger.contract.CountAssetsByState(ledger.tx(testOutsider), "OPEN")
+	require.EqualError(t, err, `unknown state "OPEN"`)
+}
+
+func TestGetMaturedAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	for assetID, state := range map[string]State{"matured-trading": TRADING, "matured-pending": PENDING, "matured-redeemed": REDEEMED, "matured-issued": ISSUED} {
+		asset := newTestAsset(assetID, state)
+		asset.EndDate = 20210531
+		ledger.putTestAsset(asset)
+	}
+	dueToday := newTestAsset("due-today", TRADING)
+	dueToday.EndDate = 20210601
+	ledger.putTestAsset(dueToday)
+	ledger.putTestAsset(newTestAsset("running", TRADING))
+
+	assets, err := ledger.contract.GetMaturedAssets(ledger.tx(testOutsider), 20210601)
+	require.NoError(t, err)
+	require.Equal(t, []string{"due-today", "matured-pending", "matured-trading"}, testAssetIDs(assets))
+
+	// the transaction date is used when no date is given
+	assets, err = ledger.contract.GetMaturedAssets(ledger.tx(testOutsider), 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"due-today", "matured-pending", "matured-trading"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.GetMaturedAssets(ledger.tx(testOutsider), 20211231)
+	require.NoError(t, err)
+	require.Equal(t, []string{"due-today", "matured-pending", "matured-trading", "running"}, testAssetIDs(assets))
+
+	_, err = ledger.contract.GetMaturedAssets(ledger.tx(testOutsider), 20211301)
+	require.EqualError(t, err, "date 20211301 is not a valid YYYYMMDD date")
+}
+
+func TestGenerateRegulatoryReport(t *testing.T) {
+	ledger := newTestLedger(t)
+	overdue := newTestAsset("overdue", TRADING)
+	overdue.EndDate = 20210531
+	overdue.Amount = 300
+	ledger.putTestAsset(overdue)
+	ledger.putTestAsset(newTestAsset("trading", TRADING))
+	ledger.putTestAsset(newTestAsset("pending", PENDING))
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+	ledger.putTestAsset(newTestAsset("defaulted", WRITTEN_OFF))
+	overdueEUR := newTestAsset("overdueEUR", TRADING)
+	overdueEUR.Currency = "EUR"
+	overdueEUR.EndDate = 20210531
+	overdueEUR.Amount = 500
+	ledger.putTestAsset(overdueEUR)
+
+	report, err := ledger.contract.GenerateRegulatoryReport(ledger.tx(testOutsider), 20210601)
+	require.NoError(t, err)
+	require.Equal(t, &RegulatoryReport{
+		AsOf:             20210601,
+		TotalLoans:       7,
+		TotalOutstanding: map[string]int{"USD": 3300, "EUR": 500},
+		CountsByState:    map[string]int{"ISSUED": 1, "PENDING": 1, "TRADING": 3, "REDEEMED": 1, "WRITTEN_OFF": 1},
+		OverdueLoans:     2,
+		OverdueAmount:    map[string]int{"USD": 300, "EUR": 500},
+		WrittenOffLoans:  1,
+	}, report)
+
+	_, err = ledger.contract.GenerateRegulatoryReport(ledger.tx(testOutsider), 0)
+	require.EqualError(t, err, "date 0 is not a valid YYYYMMDD date")
+}
+
+func TestGetWorkQueue(t *testing.T) {
+	ledger := newTestLedger(t)
+	upcoming := newTestAsset("upcoming", TRADING)
+	upcoming.EndDate = 20210901
+	ledger.putTestAsset(upcoming)
+	overdue := newTestAsset("overdue", TRADING)
+	overdue.EndDate = 20210501
+	ledger.putTestAsset(overdue)
+	pending := newTestAsset("pending", PENDING)
+	pending.StartDate = 20210515
+	ledger.putTestAsset(pending)
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	queue, err := ledger.contract.GetWorkQueue(ledger.tx(testOutsider), 20210601)
+	require.NoError(t, err)
+
+	type item struct {
+		assetID string
+		action  string
+		dueDate int
+		overdue bool
+	}
+	items := []item{}
+	for _, workItem := range queue {
+		items = append(items, item{workItem.Asset.ID, workItem.Action, workItem.DueDate, workItem.Overdue})
+	}
+	require.Equal(t, []item{
+		{"overdue", actionMarkOverdue, 20210501, true},
+		{"pending", actionBeginTrading, 20210515, true},
+		{"upcoming", actionRecordPayment, 20210901, false},
+	}, items)
+
+	_, err = ledger.contract.GetWorkQueue(ledger.tx(testOutsider), 2021)
+	require.EqualError(t, err, "date 2021 is not a valid YYYYMMDD date")
+}
+
+func TestQueryAssetsByMSP(t *testing.T) {
+	ledger := newTestLedger(t)
+	_, err := ledger.issueTestAsset("org1-loan", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+	org2Loan := newTestAsset("org2-loan", ISSUED)
+	org2Loan.IssuerMSP = "Org2MSP"
+	ledger.putTestAsset(org2Loan)
+
+	org1Assets, err := ledger.contract.QueryAssetsByMSP(ledger.tx(testOutsider), testMSPID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"org1-loan"}, testAssetIDs(org1Assets))
+	require.Equal(t, testMSPID, org1Assets[0].IssuerMSP)
+
+	org2Assets, err := ledger.contract.QueryAssetsByMSP(ledger.tx(testOutsider), "Org2MSP")
+	require.NoError(t, err)
+	require.Equal(t, []string{"org2-loan"}, testAssetIDs(org2Assets))
+
+	_, err = ledger.contract.QueryAssetsByMSP(ledger.tx(testOutsider), "")
+	require.EqualError(t, err, "MSP ID must be a non-empty string")
+
+	// the issuer MSP comes from the client identity, which must belong to the organization of the peer
+	org2Lender := &testIdentity{name: "lender", mspID: "Org2MSP"}
+	ctx := ledger.txWithTransient(org2Lender, map[string]interface{}{"loan2": AssetPrivate{SecretMessage: "terms of loan2"}})
+	_, err = ledger.contract.IssueAsset(ctx, "loan2", 1000, 20210101, 20211231, testRegion, "")
+	require.EqualError(t, err, "failed to get verified OrgID: client from org Org2MSP is not authorized to read or write private data from an org Org1MSP peer")
+}
+
+func TestGetStateDistributionHistory(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+	ledger.advance(48 * time.Hour)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	ledger.putTestAsset(newTestAsset("loan3", TRADING))
+
+	distributions, err := ledger.contract.GetStateDistributionHistory(ledger.tx(testOutsider), []int{20210531, 20210601, 20210603})
+	require.NoError(t, err)
+	require.Equal(t, []StateDistribution{
+		{Date: 20210531, Counts: map[string]int{}},
+		{Date: 20210601, Counts: map[string]int{"ISSUED": 2}},
+		{Date: 20210603, Counts: map[string]int{"ISSUED": 1, "PENDING": 1, "TRADING": 1}},
+	}, distributions)
+
+	_, err = ledger.contract.GetStateDistributionHistory(ledger.tx(testOutsider), []int{})
+	require.EqualError(t, err, "between 1 and 12 dates must be given")
+	_, err = ledger.contract.GetStateDistributionHistory(ledger.tx(testOutsider), make([]int, maxDistributionDates+1))
+	require.EqualError(t, err, "between 1 and 12 dates must be given")
+	_, err = ledger.contract.GetStateDistributionHistory(ledger.tx(testOutsider), []int{20210631})
+	require.EqualError(t, err, "date 20210631 is not a valid YYYYMMDD date")
+}
+
+func TestFindUnauthorizedTransitions(t *testing.T) {
+	ledger := newTestLedger(t)
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testBorrower.id()))
+
+	// a lifecycle driven by the lender and borrower
+	_, err := ledger.issueTestAsset("authorized", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "authorized", testBorrower.id(), "borrower-wallet"))
+
+	// a state change written by a third party
+	hijacked := newTestAsset("hijacked", ISSUED)
+	require.NoError(t, putAsset(ledger.tx(testLender), &hijacked))
+	hijacked.State = TRADING
+	require.NoError(t, putAsset(ledger.tx(testOutsider), &hijacked))
+
+	// an asset created on behalf of another lender
+	foreign := newTestAsset("foreign", TRADING)
+	require.NoError(t, putAsset(ledger.tx(testOutsider), &foreign))
+
+	// an asset archived by its lender and unarchived by an admin
+	archived := newTestAsset("archived", REDEEMED)
+	require.NoError(t, putAsset(ledger.tx(testLender), &archived))
+	require.NoError(t, ledger.contract.ArchiveRedeemedAsset(ledger.tx(testLender), "archived"))
+	require.NoError(t, ledger.contract.UnarchiveAsset(ledger.tx(testAdmin), "archived"))
+
+	// an asset an admin restored to an earlier state
+	restored := newTestAsset("restored", TRADING)
+	issuedTx := ledger.tx(testLender)
+	require.NoError(t, putAsset(issuedTx, &restored))
+	issuedTxID := issuedTx.GetStub().GetTxID()
+	restored.State = REDEEMED
+	require.NoError(t, putAsset(ledger.tx(testLender), &restored))
+	require.NoError(t, ledger.contract.RestoreAssetFromHistory(ledger.tx(testAdmin), "restored", issuedTxID))
+	require.Equal(t, TRADING, ledger.getTestAsset("restored").State)
+	require.True(t, ledger.getTestAsset("restored").LastModifiedByAdmin)
+
+	assets, err := ledger.contract.FindUnauthorizedTransitions(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foreign", "hijacked"}, testAssetIDs(assets))
+}
+
+func TestGetAssetState(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, state := range []State{ISSUED, PENDING, TRADING, REDEEMED, WRITTEN_OFF, CANCELLED} {
+		assetID := "loan-" + state.String()
+		ledger.putTestAsset(newTestAsset(assetID, state))
+
+		name, err := ledger.contract.GetAssetState(ledger.tx(testOutsider), assetID)
+		require.NoError(t, err)
+		require.Equal(t, state.String(), name)
+	}
+
+	name, err := ledger.contract.GetAssetState(ledger.tx(testOutsider), "loan-TRADING")
+	require.NoError(t, err)
+	require.Equal(t, "TRADING", name)
+
+	_, err = ledger.contract.GetAssetState(ledger.tx(testOutsider), "missing")
+	require.EqualError(t, err, "asset missing does not exist")
+}
+
+func TestGenerateRepaymentSchedule(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	schedule, err := ledger.contract.GenerateRepaymentSchedule(ledger.tx(testBorrower), "loan1", 3, 10)
+	require.NoError(t, err)
+	require.Equal(t, []Installment{
+		{DueDate: 20210502, Principal: 333, Interest: 33, Total: 366},
+		{DueDate: 20210831, Principal: 333, Interest: 22, Total: 355},
+		{DueDate: 20211231, Principal: 334, Interest: 11, Total: 345},
+	}, schedule)
+
+	for _, installments := range []int{1, 7, 12, 364} {
+		schedule, err := ledger.contract.GenerateRepaymentSchedule(ledger.tx(testBorrower), "loan1", installments, 0)
+		require.NoError(t, err)
+		require.Len(t, schedule, installments)
+
+		principal := 0
+		for _, installment := range schedule {
+			require.Equal(t, 0, installment.Interest)
+			require.Equal(t, installment.Principal, installment.Total)
+			principal += installment.Principal
+		}
+		require.Equal(t, 1000, principal, "installments %v", installments)
+		require.Equal(t, 20211231, schedule[installments-1].DueDate)
+	}
+
+	_, err = ledger.contract.GenerateRepaymentSchedule(ledger.tx(testBorrower), "loan1", 0, 10)
+	require.EqualError(t, err, "installments must be a positive integer")
+	_, err = ledger.contract.GenerateRepaymentSchedule(ledger.tx(testBorrower), "loan1", 3, -1)
+	require.EqualError(t, err, "annual interest rate must not be negative")
+	_, err = ledger.contract.GenerateRepaymentSchedule(ledger.tx(testBorrower), "missing", 3, 10)
+	require.EqualError(t, err, "asset missing does not exist")
+}
+
+func TestAssetsWithoutStateDefaultToIssued(t *testing.T) {
+	ledger := newTestLedger(t)
+	// an asset written before states were recorded, also without a lender
+	legacy := newTestAsset("legacy", ISSUED)
+	legacy.State = 0
+	legacy.Lender = ""
+	ledger.putTestAsset(legacy)
+	ledger.putTestAsset(newTestAsset("trading", TRADING))
+
+	name, err := ledger.contract.GetAssetState(ledger.tx(testOutsider), "legacy")
+	require.NoError(t, err)
+	require.Equal(t, "ISSUED", name)
+
+	assets, err := ledger.contract.QueryAssetsByState(ledger.tx(testOutsider), "ISSUED")
+	require.NoError(t, err)
+	require.Equal(t, []string{"legacy"}, testAssetIDs(assets))
+
+	count, err := ledger.contract.CountAssetsByState(ledger.tx(testOutsider), "ISSUED")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	summary, err := ledger.contract.GetPortfolioSummary(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"ISSUED": 1, "TRADING": 1}, summary.CountByState)
+
+	repaired, err := ledger.contract.RepairMissingLenders(ledger.tx(testAdmin), testLender.id())
+	require.NoError(t, err)
+	require.Equal(t, 1, repaired)
+	require.Equal(t, ISSUED, ledger.getTestAsset("legacy").State)
+}
+
+func TestAssetValidate(t *testing.T) {
+	corrupt := func(assetID string, update func(*Asset)) Asset {
+		asset := newTestAsset(assetID, TRADING)
+		update(&asset)
+		return asset
+	}
+
+	tests := []struct {
+		asset    Asset
+		expected string
+	}{
+		{corrupt("state", func(asset *Asset) { asset.State = 99 }), "asset state is invalid: unknown state 99"},
+		{corrupt("negative", func(asset *Asset) { asset.Amount = -1 }), "asset negative is invalid: amount -1 is not positive"},
+		{corrupt("zero", func(asset *Asset) { asset.Amount = 0 }), "asset zero is invalid: amount 0 is not positive"},
+		{corrupt("dates", func(asset *Asset) { asset.EndDate = 20201231 }), "asset dates is invalid: end date 20201231 is before start date 20210101"},
+	}
+
+	for _, test := range tests {
+		require.EqualError(t, test.asset.Validate(), test.expected)
+
+		ledger := newTestLedger(t)
+		ledger.putTestAsset(test.asset)
+		_, err := ledger.contract.GetAssetState(ledger.tx(testOutsider), test.asset.ID)
+		require.EqualError(t, err, test.expected)
+		// scans still return invalid assets so the data-quality tools can find them
+		assets, err := getAllAssets(ledger.tx(testOutsider))
+		require.NoError(t, err)
+		require.Equal(t, []string{test.asset.ID}, testAssetIDs(assets))
+	}
+
+	writtenOff := newTestAsset("written-off", WRITTEN_OFF)
+	writtenOff.Amount = 0
+	require.NoError(t, writtenOff.Validate())
+}
+
+func TestScansIncludeInvalidAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("valid", TRADING))
+	endBeforeStart := newTestAsset("endBeforeStart", TRADING)
+	endBeforeStart.EndDate = 20201231
+	ledger.putTestAsset(endBeforeStart)
+
+	_, err := ledger.contract.ReadAsset(ledger.tx(testOutsider), "endBeforeStart")
+	require.EqualError(t, err, "asset endBeforeStart is invalid: end date 20201231 is before start date 20210101")
+
+	invalid, err := ledger.contract.ValidateAllDates(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"endBeforeStart"}, invalid)
+
+	page, err := ledger.contract.GetAllAssetsWithPagination(ledger.tx(testOutsider), 10, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"endBeforeStart", "valid"}, testAssetIDs(page.Assets))
+
+	summary, err := ledger.contract.GetPortfolioSummary(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.TotalAssets)
+}
+
+func TestQueryAssetsByStartDateRange(t *testing.T) {
+	ledger := newTestLedger(t)
+	for assetID, startDate := range map[string]int{"december": 20201215, "january": 20210101, "february": 20210214, "march": 20210331, "april": 20210401} {
+		asset := newTestAsset(assetID, ISSUED)
+		asset.StartDate = startDate
+		ledger.putTestAsset(asset)
+	}
+
+	assets, err := ledger.contract.QueryAssetsByStartDateRange(ledger.tx(testOutsider), 20210101, 20210331)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"january", "february", "march"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssetsByStartDateRange(ledger.tx(testOutsider), 20210214, 20210214)
+	require.NoError(t, err)
+	require.Equal(t, []string{"february"}, testAssetIDs(assets))
+
+	assets, err = ledger.contract.QueryAssetsByStartDateRange(ledger.tx(testOutsider), 20210501, 20211231)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.QueryAssetsByStartDateRange(ledger.tx(testOutsider), 20210331, 20210101)
+	require.EqualError(t, err, "from 20210331 must not be after to 20210101")
+	_, err = ledger.contract.QueryAssetsByStartDateRange(ledger.tx(testOutsider), 20210230, 20210331)
+	require.EqualError(t, err, "date 20210230 is not a valid YYYYMMDD date")
+	_, err = ledger.contract.QueryAssetsByStartDateRange(ledger.tx(testOutsider), 20210101, 202104)
+	require.EqualError(t, err, "date 202104 is not a valid YYYYMMDD date")
+}
+
+func TestGetPortfolioSummary(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	summary, err := ledger.contract.GetPortfolioSummary(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, &PortfolioSummary{
+		TotalPrincipal:   map[string]int{},
+		CountByState:     map[string]int{},
+		AveragePrincipal: map[string]float64{},
+	}, summary)
+
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	pending := newTestAsset("pending", PENDING)
+	pending.Amount = 2000
+	ledger.putTestAsset(pending)
+	trading := newTestAsset("trading", TRADING)
+	trading.Amount = 4000
+	ledger.putTestAsset(trading)
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+	ledger.putTestAsset(newTestAsset("writtenOff", WRITTEN_OFF))
+	euro := newTestAsset("euro", TRADING)
+	euro.Currency = "EUR"
+	euro.Amount = 500
+	ledger.putTestAsset(euro)
+
+	summary, err = ledger.contract.GetPortfolioSummary(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, &PortfolioSummary{
+		TotalAssets:      6,
+		TotalPrincipal:   map[string]int{"USD": 7000, "EUR": 500},
+		CountByState:     map[string]int{"ISSUED": 1, "PENDING": 1, "TRADING": 2, "REDEEMED": 1, "WRITTEN_OFF": 1},
+		AveragePrincipal: map[string]float64{"USD": 7000.0 / 3, "EUR": 500},
+	}, summary)
+}
+
+func TestGetPortfolioByCurrency(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	totals, err := ledger.contract.GetPortfolioByCurrency(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Empty(t, totals)
+
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	trading := newTestAsset("trading", TRADING)
+	trading.Amount = 4000
+	ledger.putTestAsset(trading)
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+	euro := newTestAsset("euro", PENDING)
+	euro.Currency = "EUR"
+	euro.Amount = 500
+	ledger.putTestAsset(euro)
+	writtenOff := newTestAsset("writtenOff", WRITTEN_OFF)
+	writtenOff.Currency = "EUR"
+	ledger.putTestAsset(writtenOff)
+
+	totals, err = ledger.contract.GetPortfolioByCurrency(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, map[string]CurrencyTotal{
+		"USD": {TotalOutstanding: 5000, Count: 2},
+		"EUR": {TotalOutstanding: 500, Count: 1},
+	}, totals)
+}
@@ -7,24 +7,172 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode
 
 import (
-	// "bytes"
-	// "encoding/base64"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang/protobuf/ptypes"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 const assetCollection = "publicView"
+const assetLoanCollection = "assetLoanCollection"
 const transferAgreementObjectType = "transferAgreement"
+const archiveObjectType = "archive"
+const kycObjectType = "kyc"
+const restructureObjectType = "restructure"
 
 const (
 	typeAsset        = "A"
 )
 
+// maxAssetIDLength is the maximum length in bytes of an asset ID
+const maxAssetIDLength = 64
+
+// loanAssetType is the objectType of every loan asset document
+const loanAssetType = "loan-asset"
+
+// archivedAssetType is the objectType of loan assets moved to the archive namespace, which keeps
+// them out of rich queries for loan assets
+const archivedAssetType = "archived-loan-asset"
+
+// Client identities are granted administrative rights through an attribute in their certificate
+const (
+	roleAttribute  = "role"
+	roleAdmin      = "admin"
+	roleRater      = "rater"
+	roleCompliance = "compliance"
+)
+
+// supportedCurrencies are the ISO 4217 codes loans can be denominated in
+var supportedCurrencies = []string{"USD", "EUR", "GBP", "CHF", "JPY"}
+
+// defaultCurrency is the currency of loans issued without one, and of assets stored before currencies were recorded
+const defaultCurrency = "USD"
+
+// creditRatings are the external credit ratings that can be attached to an asset
+var creditRatings = []string{
+	"AAA", "AA+", "AA", "AA-", "A+", "A", "A-",
+	"BBB+", "BBB", "BBB-", "BB+", "BB", "BB-", "B+", "B", "B-",
+	"CCC+", "CCC", "CCC-", "CC", "C", "D",
+}
+
+// State enumerates the lifecycle states of a loan asset
+type State uint
+
+const (
+	ISSUED State = iota + 1
+	PENDING
+	TRADING
+	REDEEMED
+	WRITTEN_OFF
+	CANCELLED
+)
+
+var stateNames = []string{"ISSUED", "PENDING", "TRADING", "REDEEMED", "WRITTEN_OFF", "CANCELLED"}
+
+func (state State) String() string {
+	if state < ISSUED || int(state) > len(stateNames) {
+		return "UNKNOWN"
+	}
+	return stateNames[state-1]
+}
+
+// hasCollateral reports whether collateral has been recorded for the loan
+func (asset *Asset) hasCollateral() bool {
+	return asset.Collateral.ValuationDate != 0
+}
+
+// defaultState sets the state of an asset stored without one, as written before states were recorded,
+// to ISSUED.
+func (asset *Asset) defaultState() {
+	if asset.State == 0 {
+		asset.State = ISSUED
+	}
+}
+
+// Validate checks that an asset read from the ledger has a known state, a positive amount unless it has
+// been written off, and does not end before it starts.
+func (asset *Asset) Validate() error {
+	if asset.State < ISSUED || int(asset.State) > len(stateNames) {
+		return fmt.Errorf("asset %v is invalid: unknown state %d", asset.ID, asset.State)
+	}
+	if asset.Amount < 0 || (asset.Amount == 0 && asset.State != WRITTEN_OFF) {
+		return fmt.Errorf("asset %v is invalid: amount %v is not positive", asset.ID, asset.Amount)
+	}
+	if asset.EndDate < asset.StartDate {
+		return fmt.Errorf("asset %v is invalid: end date %v is before start date %v", asset.ID, asset.EndDate, asset.StartDate)
+	}
+
+	return nil
+}
+
+// allowedTransitions lists the states each state can move to. PENDING can fall back to ISSUED
+// to cancel a pending borrower assignment.
+var allowedTransitions = map[State][]State{
+	ISSUED:  {PENDING},
+	PENDING: {TRADING, ISSUED},
+	TRADING: {REDEEMED},
+}
+
+// stateEvents maps each state to the name of the chaincode event emitted when an asset enters it
+var stateEvents = map[State]string{
+	ISSUED:      "AssetIssued",
+	PENDING:     "AssetPending",
+	TRADING:     "AssetTraded",
+	REDEEMED:    "AssetRedeemed",
+	WRITTEN_OFF: "AssetWrittenOff",
+	CANCELLED:   "AssetCancelled",
+}
+
+// eventAssetDeleted is the name of the chaincode event emitted when an asset is deleted
+const eventAssetDeleted = "AssetDeleted"
+
+// eventAssetsDeleted is the name of the chaincode event emitted when several assets are deleted in one
+// transaction, as a transaction can only carry a single chaincode event
+const eventAssetsDeleted = "AssetsDeleted"
+
+// canTransition reports whether an asset may move from state from to state to
+func canTransition(from State, to State) bool {
+	for _, allowed := range allowedTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// currency returns the currency of the loan, treating assets stored without one as the default currency
+func (asset *Asset) currency() string {
+	if len(asset.Currency) == 0 {
+		return defaultCurrency
+	}
+	return asset.Currency
+}
+
+// isActive reports whether the loan is still outstanding
+func (asset *Asset) isActive() bool {
+	return asset.State == ISSUED || asset.State == PENDING || asset.State == TRADING
+}
+
+// parseState returns the State with the given name
+func parseState(name string) (State, error) {
+	for i, stateName := range stateNames {
+		if name == stateName {
+			return State(i + 1), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown state %q", name)
+}
+
 // SmartContract of this fabric sample
 type SmartContract struct {
 	contractapi.Contract
@@ -35,121 +183,212 @@ type Asset struct {
 	ID      		 string `json:"assetID"`
 	Owner            string `json:"owner"`
 	Lender           string `json:"lender"`
+	PreviousLenders  []LenderChange `json:"previousLenders"`
 	Borrower         string `json:"borrower"`
+	State            State  `json:"currentState"`
 
 	Amount           int    `json:"amount"`
 	StartDate        int    `json:"startDate"`
 	EndDate          int    `json:"endDate"`
 
+	// The borrower and investor addresses are kept in the assetLoanCollection private data collection.
+	// Only assets stored before still carry them in the public state.
 	BorrowerAddress  string   `json:"senderAddress"`
 	InvestorAddress  string   `json:"investorAddress"`
 	OwnerAddress     string   `json:"receiverAddress"`
 	PaymentHashes    []string `json:"paymentHashes"`
+	InvestorPubKey   string   `json:"investorPubKey"`
+
+	AgreementSignature string `json:"agreementSignature"`
+	Rating             string `json:"rating"`
+	Region             string `json:"region"`
+	IssuerMSP          string `json:"issuerMSP"`
+	Currency           string `json:"currency"`
+
+	Restructured     bool `json:"restructured"`
+	RestructureCount int  `json:"restructureCount"`
+
+	Collateral Collateral `json:"collateral"`
+
+	RedemptionHash string `json:"redemptionHash"`
+	SettlementRef  string `json:"settlementRef"`
+	CancelReason   string `json:"cancelReason"`
+
+	LastModifiedBy      string `json:"lastModifiedBy"`
+	LastModifiedByAdmin bool   `json:"lastModifiedByAdmin"`
+	Version             int    `json:"version"`
+}
+
+// LenderChange records a lender an asset was handed away from, the client that authorized the change and
+// the timestamp of the transaction
+type LenderChange struct {
+	Lender       string    `json:"lender"`
+	AuthorizedBy string    `json:"authorizedBy"`
+	Timestamp    time.Time `json:"timestamp"`
 }
 
 type AssetPrivate struct {
 	SecretMessage  string `json:"secretMessage"`
 }
 
+// AssetPrivateDetails is the appraised value a buyer agrees to, kept in the buying org's collection
+type AssetPrivateDetails struct {
+	ID             string `json:"assetID"`
+	AppraisedValue int    `json:"appraisedValue"`
+}
+
+// AssetPrivateAddresses are the wallet addresses of a loan kept out of the channel ledger
+// in the assetLoanCollection private data collection
+type AssetPrivateAddresses struct {
+	BorrowerAddress string `json:"senderAddress"`
+	InvestorAddress string `json:"investorAddress"`
+}
+
+// Collateral describes the security pledged for a loan and its latest valuation
+type Collateral struct {
+	Description     string `json:"description"`
+	ValuationAmount int64  `json:"valuationAmount"`
+	ValuationDate   int    `json:"valuationDate"`
+}
+
+// RestructureTerms are the loan terms a lender proposes when restructuring a loan
+type RestructureTerms struct {
+	Amount    int `json:"amount"`
+	StartDate int `json:"startDate"`
+	EndDate   int `json:"endDate"`
+}
+
 type TransferAgreement struct {
 	ID      string `json:"assetID"`
 	BuyerID string `json:"buyerID"`
 }
 
-func (s *SmartContract) IssueAsset(ctx contractapi.TransactionContextInterface, assetID string, amount int, start int, end int) error {
+// IssueAsset creates a new ISSUED loan asset with the submitting client as lender and returns the stored asset.
+// The currency defaults to USD when empty.
+func (s *SmartContract) IssueAsset(ctx contractapi.TransactionContextInterface, assetID string, amount int, start int, end int, region string, currency string) (*Asset, error) {
 	
-	clientID, orgID, err := getClientOrgID(ctx, true)
+	err := validateAssetID(assetID)
 	if err != nil {
-		return fmt.Errorf("failed to get verified OrgID: %v", err)
+		return nil, err
 	}
 
-	resJSON, err := ctx.GetStub().GetState(assetID)
+	clientID, orgID, err := getClientOrgID(ctx, true)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
-	}
-	if resJSON != nil {
-		return fmt.Errorf("asset with id: %s already exist", assetID)
+		return nil, fmt.Errorf("failed to get verified OrgID: %v", err)
 	}
 
 	transientMap, err := ctx.GetStub().GetTransient()
 	if err != nil {
-		return fmt.Errorf("error getting transient: %v", err)
+		return nil, fmt.Errorf("error getting transient: %v", err)
 	}
 
 	transientData, ok := transientMap[assetID]
 	if !ok {
 		//log error to stdout
-		return fmt.Errorf("data for asset %v not found in the transient map", assetID)
+		return nil, fmt.Errorf("data for asset %v not found in the transient map", assetID)
 	}
 
 
 	var transientInput AssetPrivate
 	err = json.Unmarshal(transientData, &transientInput)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 	}
 
 	if len(transientInput.SecretMessage) == 0 {
-		return fmt.Errorf("message field must be a non-empty string")
+		return nil, fmt.Errorf("message field must be a non-empty string")
 	}
 
 	asset := Asset{
-		Type:              "loan-asset",
+		Type:              loanAssetType,
 		ID:                assetID,
 		Owner:             clientID,
+		Lender:            clientID,
+		State:             ISSUED,
 		Amount:            amount,
 		StartDate:         start,
 		EndDate:           end,
+		Region:            region,
+		IssuerMSP:         orgID,
+		Currency:          currency,
 	}
 
-	if len(asset.ID) == 0 {
-		return fmt.Errorf("assetID field must be a non-empty string")
-	}
-	if asset.StartDate <= 0 {
-		return fmt.Errorf("start date must be a positive integer")
-	}
-	if asset.EndDate <= 0 {
-		return fmt.Errorf("end date must be a positive integer")
-	}
-	if asset.Amount <= 0 {
-		return fmt.Errorf("amount field must be a positive integer")
+	err = readTransientCollateral(transientMap, &asset)
+	if err != nil {
+		return nil, err
 	}
 
-	assetBytes, err := json.Marshal(asset)
+	err = validateNewAsset(ctx, &asset, clientID)
 	if err != nil {
-		return fmt.Errorf("failed to create asset JSON: %v", err)
+		return nil, err
 	}
 
 	transientBytes, err := json.Marshal(transientInput)
 	if err != nil {
-		return fmt.Errorf("failed to create asset JSON: %v", err)
+		return nil, fmt.Errorf("failed to create asset JSON: %v", err)
 	}
 
-	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{assetID})
+	log.Printf("IssueAsset Put: collection %v, ID %v, owner %v", "general", assetID, orgID)
+	err = createAsset(ctx, &asset, orgID)
 	if err != nil {
-		return fmt.Errorf("failed to create composite key: %v", err)
+		return nil, err
 	}
-	
-	log.Printf("IssueAsset Put: collection %v, ID %v, owner %v", "general", assetID, orgID)
-	err = ctx.GetStub().PutState(compositeKey, assetBytes)
+
+	collectionPriv, _ := getCollectionName(ctx)
+	log.Printf("CreateAsset Put: collection %v, ID %v, owner %v", collectionPriv, assetID, orgID)
+	err = ctx.GetStub().PutPrivateData(collectionPriv, assetID, transientBytes)
 	if err != nil {
-		return fmt.Errorf("failed to put asset in public data: %v", err)
+		return nil, fmt.Errorf("failed to put Asset private details: %v", err)
 	}
 
-	// Set the endorsement policy such that an owner org peer is required to endorse future updates
-	err = setAssetStateBasedEndorsement(ctx, compositeKey, orgID)
+	err = emitAssetEvent(ctx, &asset)
 	if err != nil {
-		return fmt.Errorf("failed setting state based endorsement for owner: %v", err)
+		return nil, err
 	}
 
-	collectionPriv, _ := getCollectionName(ctx)
-	log.Printf("CreateAsset Put: collection %v, ID %v, owner %v", collectionPriv, assetID, orgID)
-	err = ctx.GetStub().PutPrivateData(collectionPriv, assetID, transientBytes)
+	return &asset, nil
+}
+
+// CreateAssetRequest are the terms of a new loan passed to CreateAssetFromJSON
+type CreateAssetRequest struct {
+	ID        string `json:"assetID"`
+	StartDate int    `json:"startDate"`
+	EndDate   int    `json:"endDate"`
+	Amount    int    `json:"amount"`
+	Region    string `json:"region"`
+	Currency  string `json:"currency"`
+}
+
+// CreateAssetFromJSON issues an asset from a JSON encoded CreateAssetRequest, e.g.
+// {"assetID":"loan1","startDate":20210101,"endDate":20220101,"amount":1000,"region":"EU","currency":"EUR"}.
+// The request goes through the same checks as IssueAsset, including the private details in the transient map.
+func (s *SmartContract) CreateAssetFromJSON(ctx contractapi.TransactionContextInterface, assetJSON string) error {
+
+	var request CreateAssetRequest
+	err := json.Unmarshal([]byte(assetJSON), &request)
 	if err != nil {
-		return fmt.Errorf("failed to put Asset private details: %v", err)
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
 	}
 
-	return nil
+	var missing []string
+	if len(request.ID) == 0 {
+		missing = append(missing, "assetID")
+	}
+	if request.StartDate == 0 {
+		missing = append(missing, "startDate")
+	}
+	if request.EndDate == 0 {
+		missing = append(missing, "endDate")
+	}
+	if request.Amount == 0 {
+		missing = append(missing, "amount")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields: %v", strings.Join(missing, ", "))
+	}
+
+	_, err = s.IssueAsset(ctx, request.ID, request.Amount, request.StartDate, request.EndDate, request.Region, request.Currency)
+	return err
 }
 
 // AgreeToTransfer is used by the potential buyer of the asset to agree to the
@@ -189,16 +428,12 @@ func (s *SmartContract) AgreeToTransfer(ctx contractapi.TransactionContextInterf
 		return fmt.Errorf("appraisedValue field must be a positive integer")
 	}
 
-	// Read asset from the private data collection
-	asset, err := s.ReadAsset(ctx, valueJSON.ID)
+	_, err = s.ReadAsset(ctx, valueJSON.ID)
 	if err != nil {
 		return fmt.Errorf("error reading asset: %v", err)
 	}
-	if asset == nil {
-		return fmt.Errorf("%v does not exist", valueJSON.ID)
-	}
 	// Verify that the client is submitting request to peer in their organization
-	err = verifyClientOrgMatchesPeerOrg(ctx)
+	err = verifyClientOrgMatchesPeerOrg(orgID)
 	if err != nil {
 		return fmt.Errorf("AgreeToTransfer cannot be performed: Error %v", err)
 	}
@@ -233,375 +468,2264 @@ func (s *SmartContract) AgreeToTransfer(ctx contractapi.TransactionContextInterf
 	return nil
 }
 
-// // TransferAsset transfers the asset to the new owner by setting a new owner ID
-// func (s *SmartContract) TransferAsset(ctx contractapi.TransactionContextInterface) error {
-
-// 	transientMap, err := ctx.GetStub().GetTransient()
-// 	if err != nil {
-// 		return fmt.Errorf("error getting transient %v", err)
-// 	}
-
-// 	// Asset properties are private, therefore they get passed in transient field
-// 	transientTransferJSON, ok := transientMap["asset_owner"]
-// 	if !ok {
-// 		return fmt.Errorf("asset owner not found in the transient map")
-// 	}
-
-// 	type assetTransferTransientInput struct {
-// 		ID       string `json:"assetID"`
-// 		BuyerMSP string `json:"buyerMSP"`
-// 	}
-
-// 	var assetTransferInput assetTransferTransientInput
-// 	err = json.Unmarshal(transientTransferJSON, &assetTransferInput)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to unmarshal JSON: %v", err)
-// 	}
-
-// 	if len(assetTransferInput.ID) == 0 {
-// 		return fmt.Errorf("assetID field must be a non-empty string")
-// 	}
-// 	if len(assetTransferInput.BuyerMSP) == 0 {
-// 		return fmt.Errorf("buyerMSP field must be a non-empty string")
-// 	}
-// 	log.Printf("TransferAsset: verify asset exists ID %v", assetTransferInput.ID)
-// 	// Read asset from the private data collection
-// 	asset, err := s.ReadAsset(ctx, assetTransferInput.ID)
-// 	if err != nil {
-// 		return fmt.Errorf("error reading asset: %v", err)
-// 	}
-// 	if asset == nil {
-// 		return fmt.Errorf("%v does not exist", assetTransferInput.ID)
-// 	}
-// 	// Verify that the client is submitting request to peer in their organization
-// 	err = verifyClientOrgMatchesPeerOrg(ctx)
-// 	if err != nil {
-// 		return fmt.Errorf("TransferAsset cannot be performed: Error %v", err)
-// 	}
-
-// 	// Verify transfer details and transfer owner
-// 	err = s.verifyAgreement(ctx, assetTransferInput.ID, asset.Owner, assetTransferInput.BuyerMSP)
-// 	if err != nil {
-// 		return fmt.Errorf("failed transfer verification: %v", err)
-// 	}
-
-// 	transferAgreement, err := s.ReadTransferAgreement(ctx, assetTransferInput.ID)
-// 	if err != nil {
-// 		return fmt.Errorf("failed ReadTransferAgreement to find buyerID: %v", err)
-// 	}
-// 	if transferAgreement.BuyerID == "" {
-// 		return fmt.Errorf("BuyerID not found in TransferAgreement for %v", assetTransferInput.ID)
-// 	}
-
-// 	// Transfer asset in private data collection to new owner
-// 	asset.Owner = transferAgreement.BuyerID
-
-// 	assetJSONasBytes, err := json.Marshal(asset)
-// 	if err != nil {
-// 		return fmt.Errorf("failed marshalling asset %v: %v", assetTransferInput.ID, err)
-// 	}
-
-// 	log.Printf("TransferAsset Put: collection %v, ID %v", assetCollection, assetTransferInput.ID)
-// 	err = ctx.GetStub().PutPrivateData(assetCollection, assetTransferInput.ID, assetJSONasBytes) //rewrite the asset
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// Get collection name for this organization
-// 	ownersCollection, err := getCollectionName(ctx)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to infer private collection name for the org: %v", err)
-// 	}
-
-// 	// Delete the asset appraised value from this organization's private data collection
-// 	err = ctx.GetStub().DelPrivateData(ownersCollection, assetTransferInput.ID)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// Delete the transfer agreement from the asset collection
-// 	transferAgreeKey, err := ctx.GetStub().CreateCompositeKey(transferAgreementObjectType, []string{assetTransferInput.ID})
-// 	if err != nil {
-// 		return fmt.Errorf("failed to create composite key: %v", err)
-// 	}
-
-// 	err = ctx.GetStub().DelPrivateData(assetCollection, transferAgreeKey)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-
-// }
-
-// // verifyAgreement is an internal helper function used by TransferAsset to verify
-// // that the transfer is being initiated by the owner and that the buyer has agreed
-// // to the same appraisal value as the owner
-// func (s *SmartContract) verifyAgreement(ctx contractapi.TransactionContextInterface, assetID string, owner string, buyerMSP string) error {
-
-// 	// Check 1: verify that the transfer is being initiatied by the owner
-
-// 	// Get ID of submitting client identity
-// 	clientID, err := submittingClientIdentity(ctx)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	if clientID != owner {
-// 		return fmt.Errorf("error: submitting client identity does not own asset")
-// 	}
-
-// 	// Check 2: verify that the buyer has agreed to the appraised value
-
-// 	// Get collection names
-// 	collectionOwner, err := getCollectionName(ctx) // get owner collection from caller identity
-// 	if err != nil {
-// 		return fmt.Errorf("failed to infer private collection name for the org: %v", err)
-// 	}
-
-// 	collectionBuyer := buyerMSP + "PrivateCollection" // get buyers collection
-
-// 	// Get hash of owners agreed to value
-// 	ownerAppraisedValueHash, err := ctx.GetStub().GetPrivateDataHash(collectionOwner, assetID)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to get hash of appraised value from owners collection %v: %v", collectionOwner, err)
-// 	}
-// 	if ownerAppraisedValueHash == nil {
-// 		return fmt.Errorf("hash of appraised value for %v does not exist in collection %v", assetID, collectionOwner)
-// 	}
-
-// 	// Get hash of buyers agreed to value
-// 	buyerAppraisedValueHash, err := ctx.GetStub().GetPrivateDataHash(collectionBuyer, assetID)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to get hash of appraised value from buyer collection %v: %v", collectionBuyer, err)
-// 	}
-// 	if buyerAppraisedValueHash == nil {
-// 		return fmt.Errorf("hash of appraised value for %v does not exist in collection %v. AgreeToTransfer must be called by the buyer first", assetID, collectionBuyer)
-// 	}
-
-// 	// Verify that the two hashes match
-// 	if !bytes.Equal(ownerAppraisedValueHash, buyerAppraisedValueHash) {
-// 		return fmt.Errorf("hash for appraised value for owner %x does not value for seller %x", ownerAppraisedValueHash, buyerAppraisedValueHash)
-// 	}
-
-// 	return nil
-// }
-
-// // DeleteAsset can be used by the owner of the asset to delete the asset
-// func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface) error {
-
-// 	transientMap, err := ctx.GetStub().GetTransient()
-// 	if err != nil {
-// 		return fmt.Errorf("Error getting transient: %v", err)
-// 	}
-
-// 	// Asset properties are private, therefore they get passed in transient field
-// 	transientDeleteJSON, ok := transientMap["asset_delete"]
-// 	if !ok {
-// 		return fmt.Errorf("asset to delete not found in the transient map")
-// 	}
-
-// 	type assetDelete struct {
-// 		ID string `json:"assetID"`
-// 	}
-
-// 	var assetDeleteInput assetDelete
-// 	err = json.Unmarshal(transientDeleteJSON, &assetDeleteInput)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to unmarshal JSON: %v", err)
-// 	}
-
-// 	if len(assetDeleteInput.ID) == 0 {
-// 		return fmt.Errorf("assetID field must be a non-empty string")
-// 	}
-
-// 	// Verify that the client is submitting request to peer in their organization
-// 	err = verifyClientOrgMatchesPeerOrg(ctx)
-// 	if err != nil {
-// 		return fmt.Errorf("DeleteAsset cannot be performed: Error %v", err)
-// 	}
-
-// 	log.Printf("Deleting Asset: %v", assetDeleteInput.ID)
-// 	valAsbytes, err := ctx.GetStub().GetPrivateData(assetCollection, assetDeleteInput.ID) //get the asset from chaincode state
-// 	if err != nil {
-// 		return fmt.Errorf("failed to read asset: %v", err)
-// 	}
-// 	if valAsbytes == nil {
-// 		return fmt.Errorf("asset not found: %v", assetDeleteInput.ID)
-// 	}
-
-// 	ownerCollection, err := getCollectionName(ctx) // Get owners collection
-// 	if err != nil {
-// 		return fmt.Errorf("failed to infer private collection name for the org: %v", err)
-// 	}
-
-// 	//check the asset is in the caller org's private collection
-// 	valAsbytes, err = ctx.GetStub().GetPrivateData(ownerCollection, assetDeleteInput.ID)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to read asset from owner's Collection: %v", err)
-// 	}
-// 	if valAsbytes == nil {
-// 		return fmt.Errorf("asset not found in owner's private Collection %v: %v", ownerCollection, assetDeleteInput.ID)
-// 	}
-
-// 	// delete the asset from state
-// 	err = ctx.GetStub().DelPrivateData(assetCollection, assetDeleteInput.ID)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to delete state: %v", err)
-// 	}
-
-// 	// Finally, delete private details of asset
-// 	err = ctx.GetStub().DelPrivateData(ownerCollection, assetDeleteInput.ID)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-
-// }
-
-// // DeleteTranferAgreement can be used by the buyer to withdraw a proposal from
-// // the asset collection and from his own collection.
-// func (s *SmartContract) DeleteTranferAgreement(ctx contractapi.TransactionContextInterface) error {
-
-// 	transientMap, err := ctx.GetStub().GetTransient()
-// 	if err != nil {
-// 		return fmt.Errorf("error getting transient: %v", err)
-// 	}
-
-// 	// Asset properties are private, therefore they get passed in transient field
-// 	transientDeleteJSON, ok := transientMap["agreement_delete"]
-// 	if !ok {
-// 		return fmt.Errorf("asset to delete not found in the transient map")
-// 	}
-
-// 	type assetDelete struct {
-// 		ID string `json:"assetID"`
-// 	}
-
-// 	var assetDeleteInput assetDelete
-// 	err = json.Unmarshal(transientDeleteJSON, &assetDeleteInput)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to unmarshal JSON: %v", err)
-// 	}
-
-// 	if len(assetDeleteInput.ID) == 0 {
-// 		return fmt.Errorf("transient input ID field must be a non-empty string")
-// 	}
-
-// 	// Verify that the client is submitting request to peer in their organization
-// 	err = verifyClientOrgMatchesPeerOrg(ctx)
-// 	if err != nil {
-// 		return fmt.Errorf("DeleteTranferAgreement cannot be performed: Error %v", err)
-// 	}
-// 	// Delete private details of agreement
-// 	orgCollection, err := getCollectionName(ctx) // Get proposers collection.
-// 	if err != nil {
-// 		return fmt.Errorf("failed to infer private collection name for the org: %v", err)
-// 	}
-// 	tranferAgreeKey, err := ctx.GetStub().CreateCompositeKey(transferAgreementObjectType, []string{assetDeleteInput.
-// 		ID}) // Create composite key
-// 	if err != nil {
-// 		return fmt.Errorf("failed to create composite key: %v", err)
-// 	}
-
-// 	valAsbytes, err := ctx.GetStub().GetPrivateData(assetCollection, tranferAgreeKey) //get the transfer_agreement
-// 	if err != nil {
-// 		return fmt.Errorf("failed to read transfer_agreement: %v", err)
-// 	}
-// 	if valAsbytes == nil {
-// 		return fmt.Errorf("asset's transfer_agreement does not exist: %v", assetDeleteInput.ID)
-// 	}
-
-// 	log.Printf("Deleting TranferAgreement: %v", assetDeleteInput.ID)
-// 	err = ctx.GetStub().DelPrivateData(orgCollection, assetDeleteInput.ID) // Delete the asset
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// Delete transfer agreement record
-// 	err = ctx.GetStub().DelPrivateData(assetCollection, tranferAgreeKey) // remove agreement from state
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-
-// }
+// RepairMissingLenders sets the lender of every asset that has no lender recorded to defaultLender.
+// It can only be called by an admin and returns the number of assets that were repaired.
+func (s *SmartContract) RepairMissingLenders(ctx contractapi.TransactionContextInterface, defaultLender string) (int, error) {
 
-// getCollectionName is an internal helper function to get collection of submitting client identity.
-func getCollectionName(ctx contractapi.TransactionContextInterface) (string, error) {
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return 0, err
+	}
 
-	// Get the MSP ID of submitting client identity
-	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if len(defaultLender) == 0 {
+		return 0, fmt.Errorf("default lender must be a non-empty string")
+	}
+
+	assets, err := getAllAssets(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get verified MSPID: %v", err)
+		return 0, fmt.Errorf("failed to read assets: %v", err)
 	}
 
-	// Create the collection name
-	orgCollection := clientMSPID + "_view"
+	repaired := 0
+	for _, asset := range assets {
+		if len(asset.Lender) != 0 {
+			continue
+		}
 
-	return orgCollection, nil
+		asset.Lender = normalizeIdentity(defaultLender)
+		err = putAsset(ctx, asset)
+		if err != nil {
+			return 0, fmt.Errorf("failed to repair lender of asset %v: %v", asset.ID, err)
+		}
+		repaired++
+	}
+
+	log.Printf("RepairMissingLenders: repaired %v assets", repaired)
+	return repaired, nil
 }
 
-func getClientOrgID(ctx contractapi.TransactionContextInterface, verifyOrg bool) (string, string, error) {
-	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+// SignAgreement stores the borrower's signature over the loan terms for non-repudiation.
+// The signature is the hex encoded ASN.1 ECDSA signature over the SHA-256 digest of the
+// canonical asset terms, and can be checked later by calling VerifyAgreement.
+func (s *SmartContract) SignAgreement(ctx contractapi.TransactionContextInterface, assetID string, signatureHex string) error {
+
+	clientID, _, err := getClientOrgID(ctx, false)
 	if err != nil {
-		return "", "", fmt.Errorf("failed getting client's orgID: %v", err)
+		return fmt.Errorf("failed to get client identity: %v", err)
 	}
 
-	clientID, err := ctx.GetClientIdentity().GetID()
+	asset, err := readAsset(ctx, assetID)
 	if err != nil {
-		return "", "", fmt.Errorf("failed getting client's orgID: %v", err)
+		return err
 	}
 
-	if verifyOrg {
-		err = verifyClientOrgMatchesPeerOrg(clientOrgID)
+	if len(asset.Borrower) == 0 || clientID != normalizeIdentity(asset.Borrower) {
+		return fmt.Errorf("submitting client is not the borrower of asset %v", assetID)
+	}
+
+	_, err = hex.DecodeString(signatureHex)
+	if err != nil || len(signatureHex) == 0 {
+		return fmt.Errorf("signature must be a non-empty hex string")
+	}
+
+	asset.AgreementSignature = signatureHex
+
+	log.Printf("SignAgreement Put: ID %v", assetID)
+	return putAsset(ctx, asset)
+}
+
+// SetRating attaches an external credit rating to an asset. Only clients with the rater role can rate assets.
+func (s *SmartContract) SetRating(ctx contractapi.TransactionContextInterface, assetID string, rating string) error {
+
+	err := verifyClientRole(ctx, roleRater)
+	if err != nil {
+		return err
+	}
+
+	err = validateRating(rating)
+	if err != nil {
+		return err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	asset.Rating = rating
+
+	log.Printf("SetRating Put: ID %v, rating %v", assetID, rating)
+	return putAsset(ctx, asset)
+}
+
+// maxGeneratedAssets caps the number of assets a single GenerateTestAssets call can create
+const maxGeneratedAssets = 100
+
+// GenerateTestAssets creates count assets owned by the caller from a JSON asset template, e.g.
+// {"assetID":"loan","amount":1000,"startDate":20210101,"endDate":20220101}. The template ID is
+// used as a prefix followed by an incrementing suffix, so the example creates loan1, loan2, ...
+// Every generated asset passes the checks IssueAsset makes. Test assets can only be generated on a
+// network an admin has marked as a dev network.
+func (s *SmartContract) GenerateTestAssets(ctx contractapi.TransactionContextInterface, count int, template string) ([]string, error) {
+
+	var devNetwork bool
+	_, err := getConfig(ctx, configDevNetwork, &devNetwork)
+	if err != nil {
+		return nil, err
+	}
+	if !devNetwork {
+		return nil, fmt.Errorf("test assets can only be generated on a dev network")
+	}
+
+	if count <= 0 || count > maxGeneratedAssets {
+		return nil, fmt.Errorf("count must be between 1 and %v", maxGeneratedAssets)
+	}
+
+	clientID, orgID, err := getClientOrgID(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get verified OrgID: %v", err)
+	}
+
+	var templateAsset Asset
+	err = json.Unmarshal([]byte(template), &templateAsset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	if len(templateAsset.ID) == 0 {
+		return nil, fmt.Errorf("assetID field must be a non-empty string")
+	}
+
+	if templateAsset.hasCollateral() {
+		err = validateCollateral(templateAsset.Collateral)
 		if err != nil {
-			return "", "",err
+			return nil, err
 		}
 	}
 
-	return clientID, clientOrgID, nil
+	assets := make([]Asset, 0, count)
+	for i := 1; i <= count; i++ {
+		asset := Asset{
+			Type:       loanAssetType,
+			ID:         fmt.Sprintf("%s%d", templateAsset.ID, i),
+			Owner:      clientID,
+			Lender:     clientID,
+			State:      ISSUED,
+			Amount:     templateAsset.Amount,
+			StartDate:  templateAsset.StartDate,
+			EndDate:    templateAsset.EndDate,
+			Region:     templateAsset.Region,
+			IssuerMSP:  orgID,
+			Currency:   templateAsset.Currency,
+			Collateral: templateAsset.Collateral,
+		}
+
+		err = validateNewAsset(ctx, &asset, clientID)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	err = verifyLenderLoanLimit(ctx, clientID, len(assets))
+	if err != nil {
+		return nil, err
+	}
+
+	var assetIDs []string
+	for i := range assets {
+		err = createAsset(ctx, &assets[i], orgID)
+		if err != nil {
+			return nil, err
+		}
+		assetIDs = append(assetIDs, assets[i].ID)
+	}
+
+	log.Printf("GenerateTestAssets: created %v assets", len(assetIDs))
+	return assetIDs, nil
 }
 
-// verifyClientOrgMatchesPeerOrg checks the client org id matches the peer org id.
-func verifyClientOrgMatchesPeerOrg(clientOrgID string) error {
-	peerOrgID, err := shim.GetMSPID()
+// CreateAssets issues a batch of loans in one transaction from a JSON array of asset definitions such as
+// [{"assetID":"loan1","amount":1000,"startDate":20210101,"endDate":20220101,"region":"EU"}].
+// Every definition passes the checks IssueAsset makes before any asset is written, so the whole batch
+// is rejected if one asset is invalid or already exists. The assets are ISSUED with the caller as lender.
+func (s *SmartContract) CreateAssets(ctx contractapi.TransactionContextInterface, assetsJSON string) ([]string, error) {
+
+	clientID, orgID, err := getClientOrgID(ctx, true)
 	if err != nil {
-		return fmt.Errorf("failed getting peer's orgID: %v", err)
+		return nil, fmt.Errorf("failed to get verified OrgID: %v", err)
 	}
 
-	if clientOrgID != peerOrgID {
-		return fmt.Errorf("client from org %s is not authorized to read or write private data from an org %s peer",
-			clientOrgID,
-			peerOrgID,
-		)
+	var definitions []Asset
+	err = json.Unmarshal([]byte(assetsJSON), &definitions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	if len(definitions) == 0 {
+		return nil, fmt.Errorf("at least one asset must be given")
 	}
 
-	return nil
+	assets := make([]Asset, 0, len(definitions))
+	seen := make(map[string]bool)
+	for _, definition := range definitions {
+		if len(definition.ID) == 0 {
+			return nil, fmt.Errorf("assetID field must be a non-empty string")
+		}
+		if seen[definition.ID] {
+			return nil, fmt.Errorf("asset %v is given more than once", definition.ID)
+		}
+		seen[definition.ID] = true
+
+		asset := Asset{
+			Type:      loanAssetType,
+			ID:        definition.ID,
+			Owner:     clientID,
+			Lender:    clientID,
+			State:     ISSUED,
+			Amount:    definition.Amount,
+			StartDate: definition.StartDate,
+			EndDate:   definition.EndDate,
+			Region:    definition.Region,
+			IssuerMSP: orgID,
+			Currency:  definition.Currency,
+		}
+
+		if definition.hasCollateral() {
+			err = validateCollateral(definition.Collateral)
+			if err != nil {
+				return nil, fmt.Errorf("asset %v: %v", definition.ID, err)
+			}
+			asset.Collateral = definition.Collateral
+		}
+
+		err = validateNewAsset(ctx, &asset, clientID)
+		if err != nil {
+			return nil, fmt.Errorf("asset %v: %v", definition.ID, err)
+		}
+
+		assets = append(assets, asset)
+	}
+
+	err = verifyLenderLoanLimit(ctx, clientID, len(assets))
+	if err != nil {
+		return nil, err
+	}
+
+	var assetIDs []string
+	for i := range assets {
+		err = createAsset(ctx, &assets[i], orgID)
+		if err != nil {
+			return nil, err
+		}
+		assetIDs = append(assetIDs, assets[i].ID)
+	}
+
+	log.Printf("CreateAssets: created %v assets", len(assetIDs))
+	return assetIDs, nil
 }
 
+// MergeLoans consolidates the source loan into the target loan: the source amount is added to the
+// target, its payment history is appended and the source asset is deleted as by DeleteAsset. Both loans must be active
+// and share the same lender and borrower, and only that lender can merge them.
+func (s *SmartContract) MergeLoans(ctx contractapi.TransactionContextInterface, targetID string, sourceID string) error {
 
-// setAssetStateBasedEndorsement adds an endorsement policy to a asset so that only a peer from an owning org
-// can update or transfer the asset.
-func setAssetStateBasedEndorsement(ctx contractapi.TransactionContextInterface, assetID string, orgToEndorse string) error {
-	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if targetID == sourceID {
+		return fmt.Errorf("cannot merge asset %v into itself", targetID)
+	}
+
+	target, err := readAsset(ctx, targetID)
 	if err != nil {
 		return err
 	}
-	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, orgToEndorse)
+
+	source, err := readAsset(ctx, sourceID)
 	if err != nil {
-		return fmt.Errorf("failed to add org to endorsement policy: %v", err)
+		return err
 	}
-	policy, err := endorsementPolicy.Policy()
+
+	err = assertCallerIsLender(ctx, target)
 	if err != nil {
-		return fmt.Errorf("failed to create endorsement policy bytes from org: %v", err)
+		return err
 	}
-	err = ctx.GetStub().SetStateValidationParameter(assetID, policy)
+
+	if !target.isActive() || !source.isActive() {
+		return fmt.Errorf("only active loans can be merged")
+	}
+	if normalizeIdentity(target.Lender) != normalizeIdentity(source.Lender) {
+		return fmt.Errorf("assets %v and %v have different lenders", targetID, sourceID)
+	}
+	if normalizeIdentity(target.Borrower) != normalizeIdentity(source.Borrower) {
+		return fmt.Errorf("assets %v and %v have different borrowers", targetID, sourceID)
+	}
+	if target.currency() != source.currency() {
+		return fmt.Errorf("assets %v and %v are denominated in different currencies", targetID, sourceID)
+	}
+
+	target.Amount += source.Amount
+	target.PaymentHashes = append(target.PaymentHashes, source.PaymentHashes...)
+
+	log.Printf("MergeLoans Put: ID %v, merged %v", targetID, sourceID)
+	err = putAsset(ctx, target)
 	if err != nil {
-		return fmt.Errorf("failed to set validation parameter on asset: %v", err)
+		return fmt.Errorf("failed to put asset %v: %v", targetID, err)
 	}
 
-	return nil
-}
\ No newline at end of file
+	err = removeAsset(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetDeletedEvent(ctx, sourceID)
+}
+
+// SplitLoan carves a tranche of splitAmount out of an active loan into a new asset newID that copies
+// the lender, borrower, dates and state of the original. Only the lender can split a loan.
+func (s *SmartContract) SplitLoan(ctx contractapi.TransactionContextInterface, assetID string, newID string, splitAmount int64) error {
+
+	_, orgID, err := getClientOrgID(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to get verified OrgID: %v", err)
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if !asset.isActive() {
+		return fmt.Errorf("asset %v is not active and cannot be split", assetID)
+	}
+
+	return splitAsset(ctx, asset, newID, splitAmount, orgID)
+}
+
+// SplitAsset sells a fraction of a TRADING loan on the secondary market by carving splitAmount out of
+// it into a new asset newID, which copies the lender, borrower, dates and state of the original.
+// Only the lender can split an asset.
+func (s *SmartContract) SplitAsset(ctx contractapi.TransactionContextInterface, assetID string, newID string, splitAmount int) error {
+
+	_, orgID, err := getClientOrgID(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to get verified OrgID: %v", err)
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if asset.State != TRADING {
+		return fmt.Errorf("asset %v is %v, only TRADING assets can be split", assetID, asset.State)
+	}
+
+	return splitAsset(ctx, asset, newID, int64(splitAmount), orgID)
+}
+
+// ArchiveRedeemedAsset moves a REDEEMED asset out of the active asset range into the archive
+// namespace, so it no longer shows up in queries over active assets. Archiving counts as a modification and
+// bumps the version of the asset. Only the lender can archive an asset.
+func (s *SmartContract) ArchiveRedeemedAsset(ctx contractapi.TransactionContextInterface, assetID string) error {
+
+	_, orgID, err := getClientOrgID(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to get verified OrgID: %v", err)
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if asset.State != REDEEMED {
+		return fmt.Errorf("asset %v is %v, only REDEEMED assets can be archived", assetID, asset.State)
+	}
+
+	archiveKey, err := ctx.GetStub().CreateCompositeKey(archiveObjectType, []string{assetID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	asset.Type = archivedAssetType
+	err = stampModification(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	assetBytes, err := json.Marshal(asset)
+	if err != nil {
+		return fmt.Errorf("failed to create asset JSON: %v", err)
+	}
+
+	log.Printf("ArchiveRedeemedAsset Put: ID %v, Key %v", assetID, archiveKey)
+	err = ctx.GetStub().PutState(archiveKey, assetBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put archived asset: %v", err)
+	}
+
+	err = setAssetStateBasedEndorsement(ctx, archiveKey, orgID)
+	if err != nil {
+		return fmt.Errorf("failed setting state based endorsement for owner: %v", err)
+	}
+
+	return deleteAsset(ctx, assetID)
+}
+
+// UnarchiveAsset moves an archived asset back into the active asset range, endorsed again by its
+// issuing organization. It can only be called by an admin and fails if an active asset with the same
+// ID has been issued in the meantime.
+func (s *SmartContract) UnarchiveAsset(ctx contractapi.TransactionContextInterface, assetID string) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	asset, err := s.GetArchivedAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	if len(asset.IssuerMSP) == 0 {
+		return fmt.Errorf("archived asset %v does not record its issuing organization", assetID)
+	}
+
+	exists, err := assetExists(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("asset with id: %s already exist", assetID)
+	}
+
+	asset.Type = loanAssetType
+
+	log.Printf("UnarchiveAsset Put: ID %v", assetID)
+	err = createAsset(ctx, asset, asset.IssuerMSP)
+	if err != nil {
+		return err
+	}
+
+	archiveKey, err := ctx.GetStub().CreateCompositeKey(archiveObjectType, []string{assetID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	return ctx.GetStub().DelState(archiveKey)
+}
+
+// AssignBorrower attaches a KYC verified borrower to an ISSUED loan and moves it to PENDING.
+// Only the lender can assign the borrower, and a loan can only be assigned once. If an admin has
+// enabled the one loan per borrower policy, a borrower with an active loan from the same lender is rejected.
+func (s *SmartContract) AssignBorrower(ctx contractapi.TransactionContextInterface, assetID string, borrower string, borrowerAddress string) error {
+
+	borrower = normalizeIdentity(borrower)
+	if len(borrower) == 0 {
+		return fmt.Errorf("borrower must be a non-empty string")
+	}
+	if len(borrowerAddress) == 0 {
+		return fmt.Errorf("borrower address must be a non-empty string")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if len(asset.Borrower) != 0 {
+		return fmt.Errorf("asset %v already has a borrower assigned", assetID)
+	}
+	if asset.State != ISSUED {
+		return fmt.Errorf("asset %v is %v, only ISSUED assets can be assigned a borrower", assetID, asset.State)
+	}
+
+	verified, err := isKYCVerified(ctx, borrower)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return fmt.Errorf("borrower %v is not KYC verified", borrower)
+	}
+
+	err = verifyNoActiveLoanFromLender(ctx, normalizeIdentity(asset.Lender), borrower)
+	if err != nil {
+		return err
+	}
+
+	asset.Borrower = borrower
+	asset.State = PENDING
+
+	log.Printf("AssignBorrower Put: ID %v, borrower %v", assetID, borrower)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	err = updatePrivateAddresses(ctx, assetID, func(addresses *AssetPrivateAddresses) {
+		addresses.BorrowerAddress = borrowerAddress
+	})
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// BeginTrading moves a PENDING asset to TRADING. If an admin has configured a cooling-off period,
+// trading is rejected until that many seconds have passed since the asset entered PENDING.
+// Only the lender can begin trading an asset.
+func (s *SmartContract) BeginTrading(ctx contractapi.TransactionContextInterface, assetID string) error {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if asset.State != PENDING {
+		return fmt.Errorf("asset %v is %v, only PENDING assets can begin trading", assetID, asset.State)
+	}
+
+	err = verifyCoolingOffElapsed(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	asset.State = TRADING
+
+	log.Printf("BeginTrading Put: ID %v", assetID)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// SetKYCVerified adds an identity to the set of KYC verified borrowers.
+// Only clients with the compliance role can manage the verified set.
+func (s *SmartContract) SetKYCVerified(ctx contractapi.TransactionContextInterface, identity string) error {
+
+	err := verifyClientRole(ctx, roleCompliance)
+	if err != nil {
+		return err
+	}
+
+	identity = normalizeIdentity(identity)
+	if len(identity) == 0 {
+		return fmt.Errorf("identity must be a non-empty string")
+	}
+
+	kycKey, err := ctx.GetStub().CreateCompositeKey(kycObjectType, []string{identity})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	log.Printf("SetKYCVerified Put: identity %v", identity)
+	return ctx.GetStub().PutState(kycKey, []byte(identity))
+}
+
+// RevokeKYC removes an identity from the set of KYC verified borrowers.
+// Only clients with the compliance role can manage the verified set.
+func (s *SmartContract) RevokeKYC(ctx contractapi.TransactionContextInterface, identity string) error {
+
+	err := verifyClientRole(ctx, roleCompliance)
+	if err != nil {
+		return err
+	}
+
+	identity = normalizeIdentity(identity)
+	verified, err := isKYCVerified(ctx, identity)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return fmt.Errorf("identity %v is not KYC verified", identity)
+	}
+
+	kycKey, err := ctx.GetStub().CreateCompositeKey(kycObjectType, []string{identity})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	log.Printf("RevokeKYC Delete: identity %v", identity)
+	return ctx.GetStub().DelState(kycKey)
+}
+
+// ProposeRestructure stores new loan terms proposed by the lender without applying them. The terms are
+// validated like those of a newly issued asset. The proposal replaces any earlier proposal and is
+// applied by an admin through ApplyRestructure.
+func (s *SmartContract) ProposeRestructure(ctx contractapi.TransactionContextInterface, assetID string, termsJSON string) error {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if !asset.isActive() {
+		return fmt.Errorf("asset %v is not active and cannot be restructured", assetID)
+	}
+
+	var terms RestructureTerms
+	err = json.Unmarshal([]byte(termsJSON), &terms)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	err = validateAssetTerms(ctx, terms.StartDate, terms.EndDate, terms.Amount)
+	if err != nil {
+		return err
+	}
+
+	proposalKey, err := ctx.GetStub().CreateCompositeKey(restructureObjectType, []string{assetID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	proposalBytes, err := json.Marshal(terms)
+	if err != nil {
+		return fmt.Errorf("failed to create restructure JSON: %v", err)
+	}
+
+	log.Printf("ProposeRestructure Put: ID %v, terms %v", assetID, string(proposalBytes))
+	return ctx.GetStub().PutState(proposalKey, proposalBytes)
+}
+
+// ApplyRestructure applies the pending restructure proposal of an active asset and removes the proposal.
+// The replaced terms are logged and remain available in the asset history. It can only be called by an admin.
+func (s *SmartContract) ApplyRestructure(ctx contractapi.TransactionContextInterface, assetID string) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	if !asset.isActive() {
+		return fmt.Errorf("asset %v is not active and cannot be restructured", assetID)
+	}
+
+	proposalKey, err := ctx.GetStub().CreateCompositeKey(restructureObjectType, []string{assetID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	proposalBytes, err := ctx.GetStub().GetState(proposalKey)
+	if err != nil {
+		return fmt.Errorf("failed to read restructure proposal: %v", err)
+	}
+	if proposalBytes == nil {
+		return fmt.Errorf("asset %v has no pending restructure proposal", assetID)
+	}
+
+	var terms RestructureTerms
+	err = json.Unmarshal(proposalBytes, &terms)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	log.Printf("ApplyRestructure: ID %v, old terms amount %v, start %v, end %v", assetID, asset.Amount, asset.StartDate, asset.EndDate)
+	asset.Amount = terms.Amount
+	asset.StartDate = terms.StartDate
+	asset.EndDate = terms.EndDate
+	asset.Restructured = true
+	asset.RestructureCount++
+
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return fmt.Errorf("failed to put asset %v: %v", assetID, err)
+	}
+
+	return ctx.GetStub().DelState(proposalKey)
+}
+
+// RecordPayment appends the hash of a repayment, a 64 character hex encoded SHA-256 digest, to the
+// payments of a PENDING or TRADING asset and returns the number of payments recorded so far.
+// A payment can only be recorded once, and paymentCurrency must be the currency of the loan; an empty
+// paymentCurrency is the default currency.
+func (s *SmartContract) RecordPayment(ctx contractapi.TransactionContextInterface, assetID string, paymentHash string, paymentCurrency string) (int, error) {
+
+	_, err := decodePaymentHash(paymentHash)
+	if err != nil {
+		return 0, err
+	}
+
+	paymentCurrency, err = validateCurrency(paymentCurrency)
+	if err != nil {
+		return 0, err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return 0, err
+	}
+
+	if paymentCurrency != asset.currency() {
+		return 0, fmt.Errorf("payment currency %v does not match the currency %v of asset %v", paymentCurrency, asset.currency(), assetID)
+	}
+
+	log.Printf("RecordPayment Put: ID %v, hash %v", assetID, paymentHash)
+	return appendPayment(ctx, asset, paymentHash)
+}
+
+// SetInvestorPubKey stores the hex encoded PKIX ECDSA P-256 public key of the investor, against which
+// RecordSignedPayment checks payment signatures. Only the lender can set the investor key.
+func (s *SmartContract) SetInvestorPubKey(ctx contractapi.TransactionContextInterface, assetID string, pubKeyHex string) error {
+
+	if len(pubKeyHex) == 0 {
+		return fmt.Errorf("public key must be a non-empty hex string")
+	}
+
+	_, err := parsePublicKey(pubKeyHex)
+	if err != nil {
+		return err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	asset.InvestorPubKey = strings.ToLower(pubKeyHex)
+
+	log.Printf("SetInvestorPubKey Put: ID %v", assetID)
+	return putAsset(ctx, asset)
+}
+
+// RecordSignedPayment records a payment like RecordPayment, but only if signatureHex is a valid ECDSA
+// signature over the payment hash made with the investor key stored on the asset. pubKeyHex must match
+// that stored key.
+func (s *SmartContract) RecordSignedPayment(ctx contractapi.TransactionContextInterface, assetID string, paymentHash string, signatureHex string, pubKeyHex string) (int, error) {
+
+	digest, err := decodePaymentHash(paymentHash)
+	if err != nil {
+		return 0, err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(asset.InvestorPubKey) == 0 {
+		return 0, fmt.Errorf("asset %v has no investor public key", assetID)
+	}
+	if !strings.EqualFold(asset.InvestorPubKey, pubKeyHex) {
+		return 0, fmt.Errorf("public key does not match the investor key of asset %v", assetID)
+	}
+
+	valid, err := verifySignature(pubKeyHex, digest, signatureHex)
+	if err != nil {
+		return 0, err
+	}
+	if !valid {
+		return 0, fmt.Errorf("signature over payment %v is invalid", paymentHash)
+	}
+
+	log.Printf("RecordSignedPayment Put: ID %v, hash %v", assetID, paymentHash)
+	return appendPayment(ctx, asset, paymentHash)
+}
+
+// RedeemAsset moves a TRADING asset to REDEEMED once the loan has been repaid. Redemption is refused
+// while currentDate (YYYYMMDD) is before the end date of the loan, and a loan without a borrower has
+// nothing to redeem. A currentDate of 0 uses the date of the transaction. The settlementRef of an off-chain settlement is stored on the asset for
+// reconciliation; pass an empty string if there is none.
+func (s *SmartContract) RedeemAsset(ctx contractapi.TransactionContextInterface, assetID string, currentDate int, settlementRef string) error {
+
+	currentDate, err := dateOrTxDate(ctx, currentDate)
+	if err != nil {
+		return err
+	}
+
+	if len(settlementRef) != 0 && len(strings.TrimSpace(settlementRef)) == 0 {
+		return fmt.Errorf("settlement reference must not be blank")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = verifyRedeemable(asset, currentDate)
+	if err != nil {
+		return err
+	}
+
+	asset.State = REDEEMED
+	asset.SettlementRef = settlementRef
+
+	log.Printf("RedeemAsset Put: ID %v, settlement %v", assetID, settlementRef)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// RedeemAssetIdempotent redeems an asset like RedeemAsset, recording the hash of the final settlement.
+// Replaying the redemption of an already REDEEMED asset with the same hash succeeds without changes,
+// while a different hash is rejected as a conflict.
+func (s *SmartContract) RedeemAssetIdempotent(ctx contractapi.TransactionContextInterface, assetID string, redemptionHash string) error {
+
+	if len(redemptionHash) == 0 {
+		return fmt.Errorf("redemption hash must be a non-empty string")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	if asset.State == REDEEMED {
+		if asset.RedemptionHash != redemptionHash {
+			return fmt.Errorf("asset %v was already redeemed with a different hash", assetID)
+		}
+		return nil
+	}
+
+	currentDate, err := txDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = verifyRedeemable(asset, currentDate)
+	if err != nil {
+		return err
+	}
+
+	asset.State = REDEEMED
+	asset.RedemptionHash = redemptionHash
+
+	log.Printf("RedeemAssetIdempotent Put: ID %v, hash %v", assetID, redemptionHash)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// TransferAsset sells a loan on the secondary market by reassigning the lender holding the receivable
+// to newLender and moving the asset to TRADING. Only the current lender can transfer the asset.
+func (s *SmartContract) TransferAsset(ctx contractapi.TransactionContextInterface, assetID string, newLender string) error {
+
+	newLender = normalizeIdentity(newLender)
+	if len(newLender) == 0 {
+		return fmt.Errorf("new lender must be a non-empty string")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if asset.State != PENDING && asset.State != TRADING {
+		return fmt.Errorf("asset %v is %v, only PENDING or TRADING assets can be transferred", assetID, asset.State)
+	}
+
+	err = changeLender(ctx, asset, newLender)
+	if err != nil {
+		return err
+	}
+	asset.State = TRADING
+
+	log.Printf("TransferAsset Put: ID %v, lender %v", assetID, newLender)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// ReassignLender hands an active loan to newLender without changing its state, recording the replaced
+// lender, the authorizing client and the time in PreviousLenders. Only the current lender can reassign a loan.
+func (s *SmartContract) ReassignLender(ctx contractapi.TransactionContextInterface, assetID string, newLender string) error {
+
+	newLender = normalizeIdentity(newLender)
+	if len(newLender) == 0 {
+		return fmt.Errorf("new lender must be a non-empty string")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if !asset.isActive() {
+		return fmt.Errorf("asset %v is %v, only active assets can be reassigned", assetID, asset.State)
+	}
+
+	err = changeLender(ctx, asset, newLender)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("ReassignLender Put: ID %v, lender %v", assetID, newLender)
+	return putAsset(ctx, asset)
+}
+
+// TradeAsset transfers a loan like TransferAsset and moves the investor payout address to the new holder.
+// The investor address is stored in the assetLoanCollection private data collection.
+func (s *SmartContract) TradeAsset(ctx contractapi.TransactionContextInterface, assetID string, newLender string, investorAddress string) error {
+
+	newLender = normalizeIdentity(newLender)
+	if len(newLender) == 0 {
+		return fmt.Errorf("new lender must be a non-empty string")
+	}
+	if len(investorAddress) == 0 {
+		return fmt.Errorf("investor address must be a non-empty string")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if asset.State != PENDING && asset.State != TRADING {
+		return fmt.Errorf("asset %v is %v, only PENDING or TRADING assets can be traded", assetID, asset.State)
+	}
+
+	err = changeLender(ctx, asset, newLender)
+	if err != nil {
+		return err
+	}
+	asset.InvestorAddress = ""
+	asset.State = TRADING
+
+	log.Printf("TradeAsset Put: ID %v, lender %v", assetID, newLender)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	err = updatePrivateAddresses(ctx, assetID, func(addresses *AssetPrivateAddresses) {
+		addresses.InvestorAddress = investorAddress
+	})
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// SetCollateral records the collateral securing a loan, replacing any earlier record.
+// Only the lender can set the collateral of an asset.
+func (s *SmartContract) SetCollateral(ctx contractapi.TransactionContextInterface, assetID string, collateralJSON string) error {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	var collateral Collateral
+	err = json.Unmarshal([]byte(collateralJSON), &collateral)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	err = validateCollateral(collateral)
+	if err != nil {
+		return err
+	}
+
+	asset.Collateral = collateral
+
+	log.Printf("SetCollateral Put: ID %v, valuation %v", assetID, collateral.ValuationAmount)
+	return putAsset(ctx, asset)
+}
+
+// UpdateAsset changes the start date, end date and amount of a loan. The terms of a loan can only be
+// changed by its lender while it is ISSUED or PENDING; all other fields of the asset are preserved.
+func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, assetID string, start int, end int, amount int) error {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	return updateAssetTerms(ctx, asset, start, end, amount)
+}
+
+// UpdateAssetWithVersion changes the terms of a loan like UpdateAsset, but only if the stored asset is
+// still at expectedVersion. A client that read an older version gets a conflict error instead of
+// overwriting changes it has not seen.
+func (s *SmartContract) UpdateAssetWithVersion(ctx contractapi.TransactionContextInterface, assetID string, expectedVersion int, start int, end int, amount int) error {
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	if asset.Version != expectedVersion {
+		return fmt.Errorf("version conflict on asset %v: expected version %v, stored version is %v", assetID, expectedVersion, asset.Version)
+	}
+
+	return updateAssetTerms(ctx, asset, start, end, amount)
+}
+
+// updateAssetTerms validates and applies new loan terms to an ISSUED or PENDING asset held by the caller.
+func updateAssetTerms(ctx contractapi.TransactionContextInterface, asset *Asset, start int, end int, amount int) error {
+	err := assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if asset.State != ISSUED && asset.State != PENDING {
+		return fmt.Errorf("asset %v is %v, only ISSUED or PENDING assets can be updated", asset.ID, asset.State)
+	}
+
+	err = validateAssetTerms(ctx, start, end, amount)
+	if err != nil {
+		return err
+	}
+
+	asset.StartDate = start
+	asset.EndDate = end
+	asset.Amount = amount
+
+	log.Printf("UpdateAsset Put: ID %v", asset.ID)
+	return putAsset(ctx, asset)
+}
+
+// WriteDown reduces the amount of an active loan by the part the lender no longer expects to recover.
+// The loan stays in its current state unless the whole amount is written down, in which case it
+// moves to WRITTEN_OFF. Only the lender can write down a loan.
+func (s *SmartContract) WriteDown(ctx contractapi.TransactionContextInterface, assetID string, amount int64, reason string) error {
+
+	if amount <= 0 {
+		return fmt.Errorf("write-down amount must be a positive integer")
+	}
+	if len(reason) == 0 {
+		return fmt.Errorf("reason must be a non-empty string")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if !asset.isActive() {
+		return fmt.Errorf("asset %v is %v, only active assets can be written down", assetID, asset.State)
+	}
+	if amount > int64(asset.Amount) {
+		return fmt.Errorf("write-down of %v exceeds the amount %v of asset %v", amount, asset.Amount, assetID)
+	}
+
+	asset.Amount -= int(amount)
+	if asset.Amount == 0 {
+		asset.State = WRITTEN_OFF
+	}
+
+	log.Printf("WriteDown Put: ID %v, amount %v, reason %v", assetID, amount, reason)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	// a partial write-down leaves the state unchanged, so there is no lifecycle event to emit
+	if asset.State != WRITTEN_OFF {
+		return nil
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// CreatePrivateAsset stores the wallet addresses of an asset in the assetLoanCollection private data
+// collection. The addresses are passed in the transient map under the "asset_addresses" key, e.g.
+// {"senderAddress":"...","investorAddress":"..."}; an address left empty keeps its stored value.
+// Addresses still held in the public state of older assets are removed from it.
+// Only the lender can store the private addresses of an asset.
+func (s *SmartContract) CreatePrivateAsset(ctx contractapi.TransactionContextInterface, assetID string) error {
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+
+	transientData, ok := transientMap["asset_addresses"]
+	if !ok {
+		return fmt.Errorf("asset_addresses not found in the transient map")
+	}
+
+	var addresses AssetPrivateAddresses
+	err = json.Unmarshal(transientData, &addresses)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	if len(addresses.BorrowerAddress) == 0 && len(addresses.InvestorAddress) == 0 {
+		return fmt.Errorf("at least one address must be given")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("CreatePrivateAsset Put: collection %v, ID %v", assetLoanCollection, assetID)
+	err = updatePrivateAddresses(ctx, assetID, func(stored *AssetPrivateAddresses) {
+		if len(addresses.BorrowerAddress) != 0 {
+			stored.BorrowerAddress = addresses.BorrowerAddress
+		}
+		if len(addresses.InvestorAddress) != 0 {
+			stored.InvestorAddress = addresses.InvestorAddress
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(asset.BorrowerAddress) == 0 && len(asset.InvestorAddress) == 0 {
+		return nil
+	}
+
+	asset.BorrowerAddress = ""
+	asset.InvestorAddress = ""
+
+	return putAsset(ctx, asset)
+}
+
+// CancelAsset retires an ISSUED or PENDING loan that was never funded by moving it to CANCELLED, keeping
+// it and its history on the ledger. Only the lender can cancel a loan.
+func (s *SmartContract) CancelAsset(ctx contractapi.TransactionContextInterface, assetID string, reason string) error {
+
+	if len(reason) == 0 {
+		return fmt.Errorf("reason must be a non-empty string")
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if asset.State != ISSUED && asset.State != PENDING {
+		return fmt.Errorf("asset %v is %v, only ISSUED or PENDING assets can be cancelled", assetID, asset.State)
+	}
+
+	asset.State = CANCELLED
+	asset.CancelReason = reason
+
+	log.Printf("CancelAsset Put: ID %v, reason %v", assetID, reason)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// SetAssetEndorsingOrgs replaces the state-based endorsement policy of an asset so that changes to it
+// must be endorsed by peers of every listed organization. Only the lender can change the policy.
+func (s *SmartContract) SetAssetEndorsingOrgs(ctx contractapi.TransactionContextInterface, assetID string, orgs []string) error {
+
+	if len(orgs) == 0 {
+		return fmt.Errorf("at least one endorsing organization must be given")
+	}
+	for _, org := range orgs {
+		if len(org) == 0 {
+			return fmt.Errorf("endorsing organization MSP IDs must be non-empty strings")
+		}
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{assetID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, orgs...)
+	if err != nil {
+		return fmt.Errorf("failed to add orgs to endorsement policy: %v", err)
+	}
+	policy, err := endorsementPolicy.Policy()
+	if err != nil {
+		return fmt.Errorf("failed to create endorsement policy bytes from orgs: %v", err)
+	}
+
+	log.Printf("SetAssetEndorsingOrgs: ID %v, orgs %v", assetID, orgs)
+	err = ctx.GetStub().SetStateValidationParameter(compositeKey, policy)
+	if err != nil {
+		return fmt.Errorf("failed to set validation parameter on asset: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteAsset removes an asset from the world state together with its wallet addresses in the
+// assetLoanCollection private data collection. Only the lender can delete an asset.
+func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, assetID string) error {
+
+	err := validateAssetID(assetID)
+	if err != nil {
+		return err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("DeleteAsset Delete: ID %v", assetID)
+	err = removeAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetDeletedEvent(ctx, assetID)
+}
+
+// DeleteAllAssetsForLender removes every asset held by the submitting client as DeleteAsset does and
+// returns how many were deleted. A single AssetsDeleted event lists the IDs of the deleted assets.
+// Assets of other lenders are never touched.
+func (s *SmartContract) DeleteAllAssetsForLender(ctx contractapi.TransactionContextInterface) (int, error) {
+
+	clientID, err := submittingClientIdentity(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	assets, err := getAllAssets(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted []string
+	for _, asset := range assets {
+		if normalizeIdentity(asset.Lender) != clientID {
+			continue
+		}
+
+		err = removeAsset(ctx, asset.ID)
+		if err != nil {
+			return 0, err
+		}
+		deleted = append(deleted, asset.ID)
+	}
+
+	log.Printf("DeleteAllAssetsForLender: deleted %v assets", len(deleted))
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	return len(deleted), emitAssetsDeletedEvent(ctx, deleted)
+}
+
+// RestoreAssetFromHistory rewrites the asset value recorded by transaction txID as the current state of
+// the asset, e.g. to revert an erroneous write. An asset that has since been deleted is recreated, endorsed
+// again by its issuing organization. The restored asset gets a version above every recorded version.
+// It can only be called by an admin.
+func (s *SmartContract) RestoreAssetFromHistory(ctx contractapi.TransactionContextInterface, assetID string, txID string) error {
+
+	err := verifyClientRole(ctx, roleAdmin)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := getAssetRevisions(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	var restored *Asset
+	found := false
+	latestVersion := 0
+	for _, revision := range revisions {
+		if revision.asset != nil && revision.asset.Version > latestVersion {
+			latestVersion = revision.asset.Version
+		}
+		if revision.txID != txID {
+			continue
+		}
+
+		found = true
+		if revision.isDelete {
+			return fmt.Errorf("transaction %v deleted asset %v, there is no value to restore", txID, assetID)
+		}
+		restored = revision.asset
+	}
+	if !found {
+		return fmt.Errorf("transaction %v is not in the history of asset %v", txID, assetID)
+	}
+
+	err = restored.Validate()
+	if err != nil {
+		return err
+	}
+
+	restored.Version = latestVersion
+
+	exists, err := assetExists(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	if !exists && len(restored.IssuerMSP) == 0 {
+		return fmt.Errorf("deleted asset %v does not record its issuing organization", assetID)
+	}
+
+	log.Printf("RestoreAssetFromHistory Put: ID %v, txID %v", assetID, txID)
+	if exists {
+		err = putAsset(ctx, restored)
+	} else {
+		err = createAsset(ctx, restored, restored.IssuerMSP)
+	}
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, restored)
+}
+
+// ChangeState moves an asset to the target state, given by name, following the loan lifecycle
+// ISSUED -> PENDING -> TRADING -> REDEEMED, where PENDING may also return to ISSUED, which removes
+// the borrower again. A loan only becomes PENDING through AssignBorrower, which checks the borrower.
+// The checks of BeginTrading and RedeemAsset apply to the corresponding transitions.
+// Only the lender can change the state of an asset.
+func (s *SmartContract) ChangeState(ctx contractapi.TransactionContextInterface, assetID string, target string) error {
+
+	targetState, err := parseState(target)
+	if err != nil {
+		return err
+	}
+
+	asset, err := readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = assertCallerIsLender(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if !canTransition(asset.State, targetState) {
+		return fmt.Errorf("invalid transition from %v to %v", asset.State, targetState)
+	}
+
+	switch targetState {
+	case PENDING:
+		err = fmt.Errorf("asset %v can only become PENDING by assigning a borrower through AssignBorrower", assetID)
+	case ISSUED:
+		asset.Borrower = ""
+		asset.BorrowerAddress = ""
+		err = clearPrivateBorrowerAddress(ctx, assetID)
+	case TRADING:
+		err = verifyCoolingOffElapsed(ctx, asset)
+	case REDEEMED:
+		var currentDate int
+		currentDate, err = txDate(ctx)
+		if err == nil {
+			err = verifyRedeemable(asset, currentDate)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	asset.State = targetState
+
+	log.Printf("ChangeState Put: ID %v, state %v", assetID, targetState)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	return emitAssetEvent(ctx, asset)
+}
+
+// BulkRevalueCollateral applies new collateral valuations, given as a JSON object mapping asset IDs
+// to valuation amounts, all as of valuationDate (YYYYMMDD). Every asset is validated before any is
+// updated, so either all valuations are applied or none. The caller must be the lender of every asset.
+func (s *SmartContract) BulkRevalueCollateral(ctx contractapi.TransactionContextInterface, valuationsJSON string, valuationDate int) error {
+
+	var valuations map[string]int64
+	err := json.Unmarshal([]byte(valuationsJSON), &valuations)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	if len(valuations) == 0 {
+		return fmt.Errorf("no valuations supplied")
+	}
+
+	_, err = parseDate(valuationDate)
+	if err != nil {
+		return err
+	}
+
+	// Iterate in a fixed order so every endorser produces the same result
+	assetIDs := make([]string, 0, len(valuations))
+	for assetID := range valuations {
+		assetIDs = append(assetIDs, assetID)
+	}
+	sort.Strings(assetIDs)
+
+	var assets []*Asset
+	for _, assetID := range assetIDs {
+		asset, err := readAsset(ctx, assetID)
+		if err != nil {
+			return err
+		}
+
+		err = assertCallerIsLender(ctx, asset)
+		if err != nil {
+			return err
+		}
+
+		if !asset.hasCollateral() {
+			return fmt.Errorf("asset %v has no collateral to revalue", assetID)
+		}
+		if valuations[assetID] <= 0 {
+			return fmt.Errorf("valuation of asset %v must be a positive integer", assetID)
+		}
+
+		assets = append(assets, asset)
+	}
+
+	for _, asset := range assets {
+		asset.Collateral.ValuationAmount = valuations[asset.ID]
+		asset.Collateral.ValuationDate = valuationDate
+
+		err = putAsset(ctx, asset)
+		if err != nil {
+			return fmt.Errorf("failed to put asset %v: %v", asset.ID, err)
+		}
+	}
+
+	log.Printf("BulkRevalueCollateral: revalued %v assets", len(assets))
+	return nil
+}
+
+// getCollectionName is an internal helper function to get collection of submitting client identity.
+func getCollectionName(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	// Get the MSP ID of submitting client identity
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get verified MSPID: %v", err)
+	}
+
+	// Create the collection name
+	orgCollection := clientMSPID + "_view"
+
+	return orgCollection, nil
+}
+
+func getClientOrgID(ctx contractapi.TransactionContextInterface, verifyOrg bool) (string, string, error) {
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	clientID, err := submittingClientIdentity(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	if verifyOrg {
+		err = verifyClientOrgMatchesPeerOrg(clientOrgID)
+		if err != nil {
+			return "", "",err
+		}
+	}
+
+	return clientID, clientOrgID, nil
+}
+
+// getClientMSPID returns the MSP ID of the organization of the submitting client.
+func getClientMSPID(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+
+	return mspID, nil
+}
+
+// submittingClientIdentity returns the decoded and normalized identity of the submitting client.
+func submittingClientIdentity(ctx contractapi.TransactionContextInterface) (string, error) {
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read clientID: %v", err)
+	}
+
+	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to base64 decode clientID: %v", err)
+	}
+
+	clientID := normalizeIdentity(string(decodeID))
+	if len(clientID) == 0 {
+		return "", fmt.Errorf("submitting client identity is empty")
+	}
+
+	return clientID, nil
+}
+
+// normalizeIdentity canonicalizes an identity of the form x509::<subject DN>::<issuer DN>
+// so that equivalent identities compare equal regardless of whitespace or the casing of
+// the DN attribute types. Identities in any other form are only trimmed.
+func normalizeIdentity(identity string) string {
+	identity = strings.TrimSpace(identity)
+
+	parts := strings.Split(identity, "::")
+	if len(parts) != 3 || !strings.EqualFold(strings.TrimSpace(parts[0]), "x509") {
+		return identity
+	}
+
+	return "x509::" + normalizeDN(parts[1]) + "::" + normalizeDN(parts[2])
+}
+
+// normalizeDN trims every component of a distinguished name and upper cases its attribute type.
+func normalizeDN(dn string) string {
+	components := strings.Split(dn, ",")
+	for i, component := range components {
+		attribute := strings.SplitN(component, "=", 2)
+		if len(attribute) != 2 {
+			components[i] = strings.TrimSpace(component)
+			continue
+		}
+		components[i] = strings.ToUpper(strings.TrimSpace(attribute[0])) + "=" + strings.TrimSpace(attribute[1])
+	}
+
+	return strings.Join(components, ",")
+}
+
+// verifyClientOrgMatchesPeerOrg checks the client org id matches the peer org id.
+func verifyClientOrgMatchesPeerOrg(clientOrgID string) error {
+	peerOrgID, err := shim.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed getting peer's orgID: %v", err)
+	}
+
+	if clientOrgID != peerOrgID {
+		return fmt.Errorf("client from org %s is not authorized to read or write private data from an org %s peer",
+			clientOrgID,
+			peerOrgID,
+		)
+	}
+
+	return nil
+}
+
+
+// setAssetStateBasedEndorsement adds an endorsement policy to a asset so that only a peer from an owning org
+// can update or transfer the asset.
+func setAssetStateBasedEndorsement(ctx contractapi.TransactionContextInterface, assetID string, orgToEndorse string) error {
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, orgToEndorse)
+	if err != nil {
+		return fmt.Errorf("failed to add org to endorsement policy: %v", err)
+	}
+	policy, err := endorsementPolicy.Policy()
+	if err != nil {
+		return fmt.Errorf("failed to create endorsement policy bytes from org: %v", err)
+	}
+	err = ctx.GetStub().SetStateValidationParameter(assetID, policy)
+	if err != nil {
+		return fmt.Errorf("failed to set validation parameter on asset: %v", err)
+	}
+
+	return nil
+}
+
+// putAsset writes the asset to the world state under its composite key, recording the submitting
+// client as the last identity to modify it, whether that client is an admin, and incrementing the asset version.
+func putAsset(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{asset.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	err = stampModification(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	assetBytes, err := json.Marshal(asset)
+	if err != nil {
+		return fmt.Errorf("failed to create asset JSON: %v", err)
+	}
+
+	return ctx.GetStub().PutState(compositeKey, assetBytes)
+}
+
+// stampModification records the submitting client as the last modifier of the asset and bumps its version.
+func stampModification(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	clientID, err := submittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	asset.LastModifiedBy = clientID
+	asset.LastModifiedByAdmin = verifyClientRole(ctx, roleAdmin) == nil
+	asset.Version++
+
+	return nil
+}
+
+// verifyClientRole checks that the submitting client identity carries the given role attribute.
+func verifyClientRole(ctx contractapi.TransactionContextInterface, role string) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(roleAttribute, role)
+	if err != nil {
+		return fmt.Errorf("submitting client is not authorized as %s: %v", role, err)
+	}
+
+	return nil
+}
+
+// parseDate converts a YYYYMMDD date into a time, rejecting values that are not valid calendar dates.
+func parseDate(date int) (time.Time, error) {
+	parsed, err := time.Parse("20060102", strconv.Itoa(date))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %v is not a valid YYYYMMDD date", date)
+	}
+
+	return parsed, nil
+}
+
+// validateAssetTerms checks the dates and amount of a new or updated loan, including the configured maximum
+// loan term. It only reads the ledger.
+func validateAssetTerms(ctx contractapi.TransactionContextInterface, start int, end int, amount int) error {
+	if start <= 0 {
+		return fmt.Errorf("start date must be a positive integer")
+	}
+	if end <= 0 {
+		return fmt.Errorf("end date must be a positive integer")
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount field must be a positive integer")
+	}
+
+	err := validateLoanDates(start, end)
+	if err != nil {
+		return err
+	}
+
+	return verifyLoanTerm(ctx, start, end)
+}
+
+// validateNewAsset runs every check IssueAsset makes before creating asset for lender. It normalizes
+// the currency of the asset and only reads the ledger.
+func validateNewAsset(ctx contractapi.TransactionContextInterface, asset *Asset, lender string) error {
+	err := validateAssetID(asset.ID)
+	if err != nil {
+		return err
+	}
+
+	exists, err := assetExists(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("asset with id: %s already exist", asset.ID)
+	}
+
+	err = validateAssetTerms(ctx, asset.StartDate, asset.EndDate, asset.Amount)
+	if err != nil {
+		return err
+	}
+
+	asset.Currency, err = validateCurrency(asset.Currency)
+	if err != nil {
+		return err
+	}
+
+	err = verifyRegion(ctx, asset.Region)
+	if err != nil {
+		return err
+	}
+
+	err = verifyLenderLoanLimit(ctx, lender, 1)
+	if err != nil {
+		return err
+	}
+
+	err = verifyNotBlackoutDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return verifyCollateralCoverage(ctx, asset)
+}
+
+// readTransientCollateral sets the collateral securing asset from the optional "collateral" entry of the transient map.
+func readTransientCollateral(transientMap map[string][]byte, asset *Asset) error {
+	collateralData, ok := transientMap["collateral"]
+	if !ok {
+		return nil
+	}
+
+	err := json.Unmarshal(collateralData, &asset.Collateral)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	return validateCollateral(asset.Collateral)
+}
+
+// validateLoanDates checks that start and end are valid YYYYMMDD dates and that the loan ends after it starts.
+func validateLoanDates(start int, end int) error {
+	startDate, err := parseDate(start)
+	if err != nil {
+		return fmt.Errorf("invalid start date: %v", err)
+	}
+
+	endDate, err := parseDate(end)
+	if err != nil {
+		return fmt.Errorf("invalid end date: %v", err)
+	}
+
+	if !endDate.After(startDate) {
+		return fmt.Errorf("end date %v must be after start date %v", end, start)
+	}
+
+	return nil
+}
+
+// decodePaymentHash decodes a payment hash, which must be a 64 character hex encoded SHA-256 digest.
+func decodePaymentHash(paymentHash string) ([]byte, error) {
+	hashBytes, err := hex.DecodeString(paymentHash)
+	if err != nil || len(hashBytes) != 32 {
+		return nil, fmt.Errorf("payment hash must be a 64 character hex string")
+	}
+
+	return hashBytes, nil
+}
+
+// appendPayment records a payment hash on a PENDING or TRADING asset, rejecting hashes that are
+// already recorded, and returns the number of payments recorded so far.
+func appendPayment(ctx contractapi.TransactionContextInterface, asset *Asset, paymentHash string) (int, error) {
+	if asset.State != PENDING && asset.State != TRADING {
+		return 0, fmt.Errorf("asset %v is %v, payments can only be recorded for PENDING or TRADING assets", asset.ID, asset.State)
+	}
+
+	for _, recorded := range asset.PaymentHashes {
+		if strings.EqualFold(recorded, paymentHash) {
+			return 0, fmt.Errorf("payment %v has already been recorded for asset %v", paymentHash, asset.ID)
+		}
+	}
+
+	asset.PaymentHashes = append(asset.PaymentHashes, strings.ToLower(paymentHash))
+
+	err := putAsset(ctx, asset)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(asset.PaymentHashes), nil
+}
+
+// validateCurrency checks that currency is a supported ISO 4217 code and returns it, or the default
+// currency if it is empty.
+func validateCurrency(currency string) (string, error) {
+	if len(currency) == 0 {
+		return defaultCurrency, nil
+	}
+
+	for _, supported := range supportedCurrencies {
+		if currency == supported {
+			return currency, nil
+		}
+	}
+
+	return "", fmt.Errorf("currency %q is not supported", currency)
+}
+
+// validateRating checks that rating is one of the supported credit ratings.
+func validateRating(rating string) error {
+	for _, creditRating := range creditRatings {
+		if rating == creditRating {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown credit rating %q", rating)
+}
+
+// daysBetween returns the number of days from the YYYYMMDD date from to the YYYYMMDD date to.
+func daysBetween(from int, to int) (int, error) {
+	fromDate, err := parseDate(from)
+	if err != nil {
+		return 0, err
+	}
+
+	toDate, err := parseDate(to)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(toDate.Sub(fromDate).Hours() / 24), nil
+}
+
+// validateAssetID rejects asset IDs that are empty, longer than maxAssetIDLength or contain U+0000,
+// which Fabric uses as the separator of composite keys.
+func validateAssetID(assetID string) error {
+	if len(assetID) == 0 {
+		return fmt.Errorf("assetID must be a non-empty string")
+	}
+	if len(assetID) > maxAssetIDLength {
+		return fmt.Errorf("assetID must not be longer than %v bytes", maxAssetIDLength)
+	}
+	if strings.ContainsRune(assetID, 0) {
+		return fmt.Errorf("assetID must not contain U+0000")
+	}
+
+	return nil
+}
+
+// assetExists reports whether an asset with the given ID exists in the world state.
+func assetExists(ctx contractapi.TransactionContextInterface, assetID string) (bool, error) {
+	err := validateAssetID(assetID)
+	if err != nil {
+		return false, err
+	}
+
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{assetID})
+	if err != nil {
+		return false, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	assetJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	return assetJSON != nil, nil
+}
+
+// createAsset writes a new asset to the world state and sets its endorsement policy
+// such that a peer of the owner org is required to endorse future updates.
+func createAsset(ctx contractapi.TransactionContextInterface, asset *Asset, orgID string) error {
+	err := putAsset(ctx, asset)
+	if err != nil {
+		return fmt.Errorf("failed to put asset in public data: %v", err)
+	}
+
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{asset.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	err = setAssetStateBasedEndorsement(ctx, compositeKey, orgID)
+	if err != nil {
+		return fmt.Errorf("failed setting state based endorsement for owner: %v", err)
+	}
+
+	return nil
+}
+
+// toDate converts a time into a YYYYMMDD date in UTC.
+func toDate(t time.Time) int {
+	t = t.UTC()
+	return t.Year()*10000 + int(t.Month())*100 + t.Day()
+}
+
+// deleteAsset removes the asset from the world state.
+func deleteAsset(ctx contractapi.TransactionContextInterface, assetID string) error {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(typeAsset, []string{assetID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	err = ctx.GetStub().DelState(compositeKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete asset %v: %v", assetID, err)
+	}
+
+	return nil
+}
+
+// removeAsset deletes the asset from the world state and its wallet addresses from the assetLoanCollection
+// private data collection.
+func removeAsset(ctx contractapi.TransactionContextInterface, assetID string) error {
+	err := deleteAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelPrivateData(assetLoanCollection, assetID)
+	if err != nil {
+		return fmt.Errorf("failed to delete asset addresses from private data: %v", err)
+	}
+
+	return nil
+}
+
+// changeLender hands the asset to newLender, recording the replaced lender in PreviousLenders together
+// with the submitting client and the transaction timestamp.
+func changeLender(ctx contractapi.TransactionContextInterface, asset *Asset, newLender string) error {
+	if newLender == normalizeIdentity(asset.Lender) {
+		return fmt.Errorf("%v is already the lender of asset %v", newLender, asset.ID)
+	}
+
+	clientID, err := submittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset.PreviousLenders = append(asset.PreviousLenders, LenderChange{
+		Lender:       asset.Lender,
+		AuthorizedBy: clientID,
+		Timestamp:    timestamp,
+	})
+	asset.Lender = newLender
+
+	return nil
+}
+
+// assertCallerIsLender checks that the submitting client identity is the lender of the asset.
+func assertCallerIsLender(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	clientID, err := submittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	if clientID != normalizeIdentity(asset.Lender) {
+		return fmt.Errorf("submitting client is not the lender of asset %v", asset.ID)
+	}
+
+	return nil
+}
+
+// splitAsset moves amount out of asset into a new asset newID, copying the parties, dates and state.
+func splitAsset(ctx contractapi.TransactionContextInterface, asset *Asset, newID string, amount int64, orgID string) error {
+	if len(newID) == 0 {
+		return fmt.Errorf("new assetID must be a non-empty string")
+	}
+	if amount <= 0 || amount >= int64(asset.Amount) {
+		return fmt.Errorf("split amount must be between 0 and the asset amount %v", asset.Amount)
+	}
+
+	exists, err := assetExists(ctx, newID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("asset with id: %s already exist", newID)
+	}
+
+	tranche := Asset{
+		Type:            asset.Type,
+		ID:              newID,
+		Owner:           asset.Owner,
+		Lender:          asset.Lender,
+		Borrower:        asset.Borrower,
+		State:           asset.State,
+		Amount:          int(amount),
+		StartDate:       asset.StartDate,
+		EndDate:         asset.EndDate,
+		OwnerAddress:    asset.OwnerAddress,
+		Region:          asset.Region,
+		IssuerMSP:       asset.IssuerMSP,
+		Currency:        asset.Currency,
+	}
+
+	asset.Amount -= int(amount)
+
+	log.Printf("SplitAsset Put: ID %v, new ID %v, amount %v", asset.ID, newID, amount)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return fmt.Errorf("failed to put asset %v: %v", asset.ID, err)
+	}
+
+	err = createAsset(ctx, &tranche, orgID)
+	if err != nil {
+		return err
+	}
+
+	addresses, err := getPrivateAddresses(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+	if addresses == nil {
+		return nil
+	}
+
+	return putPrivateAddresses(ctx, newID, addresses)
+}
+
+// txTime returns the transaction timestamp, which is the same on every endorsing peer.
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+
+	return ptypes.Timestamp(txTimestamp)
+}
+
+// txDate returns the YYYYMMDD date of the transaction timestamp.
+func txDate(ctx contractapi.TransactionContextInterface) (int, error) {
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return toDate(timestamp), nil
+}
+
+// dateOrTxDate validates a YYYYMMDD date supplied by the client, defaulting to the transaction date when it is 0.
+func dateOrTxDate(ctx contractapi.TransactionContextInterface, date int) (int, error) {
+	if date == 0 {
+		return txDate(ctx)
+	}
+
+	_, err := parseDate(date)
+	if err != nil {
+		return 0, err
+	}
+
+	return date, nil
+}
+
+// isKYCVerified reports whether the identity is in the set of KYC verified borrowers.
+func isKYCVerified(ctx contractapi.TransactionContextInterface, identity string) (bool, error) {
+	kycKey, err := ctx.GetStub().CreateCompositeKey(kycObjectType, []string{normalizeIdentity(identity)})
+	if err != nil {
+		return false, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	verified, err := ctx.GetStub().GetState(kycKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read KYC status: %v", err)
+	}
+
+	return verified != nil, nil
+}
+
+// getPrivateAddresses reads the wallet addresses of an asset from the assetLoanCollection private data
+// collection. It returns nil if none are stored.
+func getPrivateAddresses(ctx contractapi.TransactionContextInterface, assetID string) (*AssetPrivateAddresses, error) {
+	addressesJSON, err := ctx.GetStub().GetPrivateData(assetLoanCollection, assetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read asset addresses: %v", err)
+	}
+	if addressesJSON == nil {
+		return nil, nil
+	}
+
+	var addresses *AssetPrivateAddresses
+	err = json.Unmarshal(addressesJSON, &addresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	return addresses, nil
+}
+
+// putPrivateAddresses writes the wallet addresses of an asset to the assetLoanCollection private data collection.
+func putPrivateAddresses(ctx contractapi.TransactionContextInterface, assetID string, addresses *AssetPrivateAddresses) error {
+	addressesBytes, err := json.Marshal(addresses)
+	if err != nil {
+		return fmt.Errorf("failed to create addresses JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutPrivateData(assetLoanCollection, assetID, addressesBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put asset addresses in private data: %v", err)
+	}
+
+	return nil
+}
+
+// updatePrivateAddresses applies update to the stored wallet addresses of an asset, starting from
+// empty addresses if none are stored yet.
+func updatePrivateAddresses(ctx contractapi.TransactionContextInterface, assetID string, update func(*AssetPrivateAddresses)) error {
+	addresses, err := getPrivateAddresses(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	if addresses == nil {
+		addresses = &AssetPrivateAddresses{}
+	}
+
+	update(addresses)
+
+	return putPrivateAddresses(ctx, assetID, addresses)
+}
+
+// clearPrivateBorrowerAddress removes the borrower address from the stored wallet addresses of an asset, if any.
+func clearPrivateBorrowerAddress(ctx contractapi.TransactionContextInterface, assetID string) error {
+	addresses, err := getPrivateAddresses(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	if addresses == nil || len(addresses.BorrowerAddress) == 0 {
+		return nil
+	}
+
+	addresses.BorrowerAddress = ""
+
+	return putPrivateAddresses(ctx, assetID, addresses)
+}
+
+// verifyRedeemable checks that an asset can move to REDEEMED on currentDate (YYYYMMDD).
+func verifyRedeemable(asset *Asset, currentDate int) error {
+	if asset.State != TRADING {
+		return fmt.Errorf("asset %v is %v, only TRADING assets can be redeemed", asset.ID, asset.State)
+	}
+	if len(asset.Borrower) == 0 {
+		return fmt.Errorf("asset %v has no borrower and cannot be redeemed", asset.ID)
+	}
+	if currentDate < asset.EndDate {
+		return fmt.Errorf("asset %v cannot be redeemed before maturity %v", asset.ID, asset.EndDate)
+	}
+
+	return nil
+}
+
+// validateCollateral checks that a collateral record is complete.
+func validateCollateral(collateral Collateral) error {
+	if len(collateral.Description) == 0 {
+		return fmt.Errorf("collateral description must be a non-empty string")
+	}
+	if collateral.ValuationAmount <= 0 {
+		return fmt.Errorf("collateral valuation amount must be a positive integer")
+	}
+
+	_, err := parseDate(collateral.ValuationDate)
+	return err
+}
+
+// emitAssetEvent sets the chaincode event for the current state of the asset, with the asset JSON as payload.
+func emitAssetEvent(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	eventName, ok := stateEvents[asset.State]
+	if !ok {
+		return fmt.Errorf("no event defined for state %v", asset.State)
+	}
+
+	assetBytes, err := json.Marshal(asset)
+	if err != nil {
+		return fmt.Errorf("failed to create asset JSON: %v", err)
+	}
+
+	err = ctx.GetStub().SetEvent(eventName, assetBytes)
+	if err != nil {
+		return fmt.Errorf("failed to set event %v: %v", eventName, err)
+	}
+
+	return nil
+}
+
+// emitAssetDeletedEvent sets the chaincode event for a deleted asset, with the asset ID as payload.
+func emitAssetDeletedEvent(ctx contractapi.TransactionContextInterface, assetID string) error {
+	err := ctx.GetStub().SetEvent(eventAssetDeleted, []byte(assetID))
+	if err != nil {
+		return fmt.Errorf("failed to set event %v: %v", eventAssetDeleted, err)
+	}
+
+	return nil
+}
+
+// emitAssetsDeletedEvent sets the chaincode event for assets deleted together, with a JSON array of
+// their IDs as payload.
+func emitAssetsDeletedEvent(ctx contractapi.TransactionContextInterface, assetIDs []string) error {
+	payload, err := json.Marshal(assetIDs)
+	if err != nil {
+		return fmt.Errorf("failed to create event payload: %v", err)
+	}
+
+	err = ctx.GetStub().SetEvent(eventAssetsDeleted, payload)
+	if err != nil {
+		return fmt.Errorf("failed to set event %v: %v", eventAssetsDeleted, err)
+	}
+
+	return nil
+}
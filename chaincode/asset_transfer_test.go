@@ -0,0 +1,1523 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChaincode(t *testing.T) {
+	_, err := contractapi.NewChaincode(new(SmartContract))
+	require.NoError(t, err)
+}
+
+func TestRepairMissingLenders(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	orphan := newTestAsset("loan1", ISSUED)
+	orphan.Lender = ""
+	ledger.putTestAsset(orphan)
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+
+	repaired, err := ledger.contract.RepairMissingLenders(ledger.tx(testAdmin), " "+testInvestor.id())
+	require.NoError(t, err)
+	require.Equal(t, 1, repaired)
+
+	require.Equal(t, testInvestor.id(), ledger.getTestAsset("loan1").Lender)
+	require.Equal(t, testLender.id(), ledger.getTestAsset("loan2").Lender)
+
+	repaired, err = ledger.contract.RepairMissingLenders(ledger.tx(testAdmin), testInvestor.id())
+	require.NoError(t, err)
+	require.Equal(t, 0, repaired)
+}
+
+func TestRepairMissingLendersRequiresAdmin(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	orphan := newTestAsset("loan1", ISSUED)
+	orphan.Lender = ""
+	ledger.putTestAsset(orphan)
+
+	_, err := ledger.contract.RepairMissingLenders(ledger.tx(testLender), testLender.id())
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+	require.Empty(t, ledger.getTestAsset("loan1").Lender)
+
+	_, err = ledger.contract.RepairMissingLenders(ledger.tx(testAdmin), "")
+	require.EqualError(t, err, "default lender must be a non-empty string")
+}
+
+func TestSignAgreement(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+
+	key := testSigningKey(t, testKeyScalar)
+	digest, err := agreementDigest(ledger.getTestAsset("loan1"))
+	require.NoError(t, err)
+	signature := testSign(t, key, digest)
+
+	err = ledger.contract.SignAgreement(ledger.tx(testLender), "loan1", signature)
+	require.EqualError(t, err, "submitting client is not the borrower of asset loan1")
+
+	err = ledger.contract.SignAgreement(ledger.tx(testBorrower), "loan1", "not hex")
+	require.EqualError(t, err, "signature must be a non-empty hex string")
+
+	require.NoError(t, ledger.contract.SignAgreement(ledger.tx(testBorrower), "loan1", signature))
+	require.Equal(t, signature, ledger.getTestAsset("loan1").AgreementSignature)
+
+	valid, err := ledger.contract.VerifyAgreement(ledger.tx(testOutsider), "loan1", testPublicKeyHex(t, key))
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	otherKey := testSigningKey(t, testOtherKeyScalar)
+	valid, err = ledger.contract.VerifyAgreement(ledger.tx(testOutsider), "loan1", testPublicKeyHex(t, otherKey))
+	require.NoError(t, err)
+	require.False(t, valid)
+}
+
+func TestVerifyAgreementDetectsChangedTerms(t *testing.T) {
+	ledger := newTestLedger(t)
+	asset := newTestAsset("loan1", PENDING)
+	ledger.putTestAsset(asset)
+
+	_, err := ledger.contract.VerifyAgreement(ledger.tx(testOutsider), "loan1", "00")
+	require.EqualError(t, err, "agreement for asset loan1 has not been signed")
+
+	key := testSigningKey(t, testKeyScalar)
+	digest, err := agreementDigest(&asset)
+	require.NoError(t, err)
+	asset.AgreementSignature = testSign(t, key, digest)
+	asset.Amount = 2000
+	ledger.putTestAsset(asset)
+
+	valid, err := ledger.contract.VerifyAgreement(ledger.tx(testOutsider), "loan1", testPublicKeyHex(t, key))
+	require.NoError(t, err)
+	require.False(t, valid)
+}
+
+func TestNormalizeIdentity(t *testing.T) {
+	tests := []struct {
+		identity string
+		expected string
+	}{
+		{
+			"x509::CN=lender,OU=client::CN=ca.org1.example.com",
+			"x509::CN=lender,OU=client::CN=ca.org1.example.com",
+		},
+		{
+			"  X509:: cn=lender , ou=client ::CN = ca.org1.example.com ",
+			"x509::CN=lender,OU=client::CN=ca.org1.example.com",
+		},
+		{
+			"x509::CN=Lender,OU=client::CN=ca.org1.example.com",
+			"x509::CN=Lender,OU=client::CN=ca.org1.example.com",
+		},
+		{"  alice  ", "alice"},
+		{"x509::CN=lender", "x509::CN=lender"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, normalizeIdentity(test.identity), "identity %q", test.identity)
+	}
+}
+
+func TestAssertCallerIsLenderComparesNormalizedIdentities(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset := newTestAsset("loan1", ISSUED)
+	asset.Lender = " x509:: cn=lender, ou=client::cn=ca.org1.example.com, o=org1.example.com"
+
+	require.NoError(t, assertCallerIsLender(ledger.tx(testLender), &asset))
+	require.EqualError(t, assertCallerIsLender(ledger.tx(testOutsider), &asset), "submitting client is not the lender of asset loan1")
+}
+
+func TestSetRating(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+	ledger.putTestAsset(newTestAsset("loan2", ISSUED))
+
+	err := ledger.contract.SetRating(ledger.tx(testLender), "loan1", "AAA")
+	require.EqualError(t, err, "submitting client is not authorized as rater: attribute 'role' was not found")
+
+	err = ledger.contract.SetRating(ledger.tx(testRater), "loan1", "AAAA")
+	require.EqualError(t, err, `unknown credit rating "AAAA"`)
+	require.Empty(t, ledger.getTestAsset("loan1").Rating)
+
+	require.NoError(t, ledger.contract.SetRating(ledger.tx(testRater), "loan1", "BBB-"))
+	require.NoError(t, ledger.contract.SetRating(ledger.tx(testRater), "loan2", "AAA"))
+
+	assets, err := ledger.contract.GetAssetsByRating(ledger.tx(testOutsider), "BBB-")
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1"}, testAssetIDs(assets))
+
+	_, err = ledger.contract.GetAssetsByRating(ledger.tx(testOutsider), "Z")
+	require.EqualError(t, err, `unknown credit rating "Z"`)
+}
+
+func TestGenerateTestAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	template := `{"assetID":"loan","amount":1000,"startDate":20210101,"endDate":20220101,"region":"EU"}`
+
+	_, err := ledger.contract.GenerateTestAssets(ledger.tx(testLender), 5, template)
+	require.EqualError(t, err, "test assets can only be generated on a dev network")
+
+	require.NoError(t, ledger.contract.SetDevNetwork(ledger.tx(testAdmin), true))
+
+	assetIDs, err := ledger.contract.GenerateTestAssets(ledger.tx(testLender), 5, template)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan2", "loan3", "loan4", "loan5"}, assetIDs)
+
+	asset := ledger.getTestAsset("loan3")
+	require.Equal(t, testLender.id(), asset.Lender)
+	require.Equal(t, ISSUED, asset.State)
+	require.Equal(t, 1000, asset.Amount)
+
+	_, err = ledger.contract.GenerateTestAssets(ledger.tx(testLender), 1, template)
+	require.EqualError(t, err, "asset with id: loan1 already exist")
+
+	_, err = ledger.contract.GenerateTestAssets(ledger.tx(testLender), maxGeneratedAssets+1, template)
+	require.EqualError(t, err, "count must be between 1 and 100")
+}
+
+func TestGenerateTestAssetsRunsIssueChecks(t *testing.T) {
+	ledger := newTestLedger(t)
+	require.NoError(t, ledger.contract.SetDevNetwork(ledger.tx(testAdmin), true))
+	template := `{"assetID":"loan","amount":1000,"startDate":20210101,"endDate":20220101}`
+
+	require.NoError(t, ledger.contract.SetBlackoutDates(ledger.tx(testAdmin), []int{20210601}))
+	_, err := ledger.contract.GenerateTestAssets(ledger.tx(testLender), 2, template)
+	require.EqualError(t, err, "assets cannot be issued on blackout date 20210601")
+	require.NoError(t, ledger.contract.SetBlackoutDates(ledger.tx(testAdmin), []int{}))
+
+	require.NoError(t, ledger.contract.SetMaxLoansPerLender(ledger.tx(testAdmin), 2))
+	_, err = ledger.contract.GenerateTestAssets(ledger.tx(testLender), 3, template)
+	require.EqualError(t, err, "lender already holds 0 active loans, the maximum is 2")
+	require.NoError(t, ledger.contract.SetMaxLoansPerLender(ledger.tx(testAdmin), 0))
+
+	require.NoError(t, ledger.contract.SetMinCollateralCoverage(ledger.tx(testAdmin), 1.5))
+	_, err = ledger.contract.GenerateTestAssets(ledger.tx(testLender), 2, template)
+	require.EqualError(t, err, "asset loan1 requires collateral covering at least 1.5 times the amount")
+
+	secured := `{"assetID":"loan","amount":1000,"startDate":20210101,"endDate":20220101,"collateral":{"description":"warehouse","valuationAmount":1500,"valuationDate":20210101}}`
+	assetIDs, err := ledger.contract.GenerateTestAssets(ledger.tx(testLender), 2, secured)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan2"}, assetIDs)
+	require.Equal(t, int64(1500), ledger.getTestAsset("loan2").Collateral.ValuationAmount)
+
+	exists, err := assetExists(ledger.tx(testOutsider), "loan3")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMergeLoans(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	target := newTestAsset("target", TRADING)
+	target.PaymentHashes = []string{"3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"}
+	ledger.putTestAsset(target)
+	source := newTestAsset("source", TRADING)
+	source.Amount = 500
+	source.PaymentHashes = []string{"be5e5d5a2d34b1bbd5bbc9dba6ecf4b0e0f3a3f1c5a81bd06d4ca2e4d0cb2a1e"}
+	ledger.putTestAsset(source)
+	addresses := AssetPrivateAddresses{BorrowerAddress: "borrower-wallet", InvestorAddress: "investor-wallet"}
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, map[string]interface{}{"asset_addresses": addresses}), "source"))
+
+	err := ledger.contract.MergeLoans(ledger.tx(testOutsider), "target", "source")
+	require.EqualError(t, err, "submitting client is not the lender of asset target")
+
+	require.NoError(t, ledger.contract.MergeLoans(ledger.tx(testLender), "target", "source"))
+	require.Equal(t, "AssetDeleted", ledger.stub.event.EventName)
+	require.Equal(t, "source", string(ledger.stub.event.Payload))
+	require.Nil(t, ledger.stub.PvtState[assetLoanCollection]["source"])
+
+	merged := ledger.getTestAsset("target")
+	require.Equal(t, 1500, merged.Amount)
+	require.Equal(t, append(target.PaymentHashes, source.PaymentHashes...), merged.PaymentHashes)
+	exists, err := assetExists(ledger.tx(testOutsider), "source")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = ledger.contract.MergeLoans(ledger.tx(testLender), "target", "target")
+	require.EqualError(t, err, "cannot merge asset target into itself")
+}
+
+func TestMergeLoansRejectsMismatchedLoans(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	ledger.putTestAsset(newTestAsset("target", TRADING))
+	euro := newTestAsset("euro", TRADING)
+	euro.Currency = "EUR"
+	ledger.putTestAsset(euro)
+	otherBorrower := newTestAsset("otherBorrower", TRADING)
+	otherBorrower.Borrower = testInvestor.id()
+	ledger.putTestAsset(otherBorrower)
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	err := ledger.contract.MergeLoans(ledger.tx(testLender), "target", "euro")
+	require.EqualError(t, err, "assets target and euro are denominated in different currencies")
+
+	err = ledger.contract.MergeLoans(ledger.tx(testLender), "target", "otherBorrower")
+	require.EqualError(t, err, "assets target and otherBorrower have different borrowers")
+
+	err = ledger.contract.MergeLoans(ledger.tx(testLender), "target", "redeemed")
+	require.EqualError(t, err, "only active loans can be merged")
+
+	require.Equal(t, 1000, ledger.getTestAsset("target").Amount)
+}
+
+func TestSplitLoan(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	addresses := AssetPrivateAddresses{BorrowerAddress: "borrower-wallet", InvestorAddress: "investor-wallet"}
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, map[string]interface{}{"asset_addresses": addresses}), "loan1"))
+
+	err := ledger.contract.SplitLoan(ledger.tx(testOutsider), "loan1", "loan2", 400)
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	require.NoError(t, ledger.contract.SplitLoan(ledger.tx(testLender), "loan1", "loan2", 400))
+
+	require.Equal(t, 600, ledger.getTestAsset("loan1").Amount)
+	tranche := ledger.getTestAsset("loan2")
+	require.Equal(t, 400, tranche.Amount)
+	require.Equal(t, TRADING, tranche.State)
+	require.Equal(t, testLender.id(), tranche.Lender)
+	require.Equal(t, testBorrower.id(), tranche.Borrower)
+	require.Equal(t, 20211231, tranche.EndDate)
+	require.Empty(t, tranche.BorrowerAddress)
+	require.Empty(t, tranche.InvestorAddress)
+	trancheAddresses, err := ledger.contract.ReadPrivateAsset(ledger.tx(testLender), "loan2")
+	require.NoError(t, err)
+	require.Equal(t, &addresses, trancheAddresses)
+
+	err = ledger.contract.SplitLoan(ledger.tx(testLender), "loan1", "loan2", 100)
+	require.EqualError(t, err, "asset with id: loan2 already exist")
+}
+
+func TestSplitLoanRejectsInvalidAmounts(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	for _, amount := range []int64{0, -1, 1000, 1001} {
+		err := ledger.contract.SplitLoan(ledger.tx(testLender), "loan1", "loan2", amount)
+		require.EqualError(t, err, "split amount must be between 0 and the asset amount 1000", "amount %v", amount)
+	}
+	require.Equal(t, 1000, ledger.getTestAsset("loan1").Amount)
+
+	err := ledger.contract.SplitLoan(ledger.tx(testLender), "redeemed", "loan2", 100)
+	require.EqualError(t, err, "asset redeemed is not active and cannot be split")
+}
+
+func TestArchiveRedeemedAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", REDEEMED))
+	ledger.putTestAsset(newTestAsset("loan2", TRADING))
+
+	err := ledger.contract.ArchiveRedeemedAsset(ledger.tx(testLender), "loan2")
+	require.EqualError(t, err, "asset loan2 is TRADING, only REDEEMED assets can be archived")
+
+	err = ledger.contract.ArchiveRedeemedAsset(ledger.tx(testOutsider), "loan1")
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	require.NoError(t, ledger.contract.ArchiveRedeemedAsset(ledger.tx(testLender), "loan1"))
+
+	exists, err := assetExists(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	archived, err := ledger.contract.GetArchivedAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1"}, testAssetIDs(archived))
+	require.Equal(t, archivedAssetType, archived[0].Type)
+	require.Equal(t, 1, archived[0].Version)
+	require.Equal(t, testLender.id(), archived[0].LastModifiedBy)
+	require.False(t, archived[0].LastModifiedByAdmin)
+
+	// the archived document must not match rich queries for loan assets
+	assets, err := ledger.contract.QueryAssets(ledger.tx(testOutsider), `{"selector":{"currentState":4}}`)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
+	_, err = ledger.contract.GetArchivedAsset(ledger.tx(testOutsider), "loan2")
+	require.EqualError(t, err, "archived asset loan2 does not exist")
+}
+
+func TestUnarchiveAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", REDEEMED))
+	require.NoError(t, ledger.contract.ArchiveRedeemedAsset(ledger.tx(testLender), "loan1"))
+
+	err := ledger.contract.UnarchiveAsset(ledger.tx(testLender), "loan1")
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+
+	// an admin of another organization restores the endorsement policy of the issuing organization
+	otherAdmin := &testIdentity{name: "admin", mspID: "Org2MSP", role: roleAdmin}
+	require.NoError(t, ledger.contract.UnarchiveAsset(ledger.tx(otherAdmin), "loan1"))
+
+	require.Equal(t, loanAssetType, ledger.getTestAsset("loan1").Type)
+	require.Equal(t, []string{testMSPID}, ledger.endorsingOrgs("loan1"))
+	archived, err := ledger.contract.GetArchivedAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Empty(t, archived)
+
+	assets, err := ledger.contract.QueryAssets(ledger.tx(testOutsider), `{"selector":{"currentState":4}}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1"}, testAssetIDs(assets))
+
+	err = ledger.contract.UnarchiveAsset(ledger.tx(testAdmin), "loan1")
+	require.EqualError(t, err, "archived asset loan1 does not exist")
+
+	legacy := newTestAsset("legacy", REDEEMED)
+	legacy.IssuerMSP = ""
+	ledger.putTestAsset(legacy)
+	require.NoError(t, ledger.contract.ArchiveRedeemedAsset(ledger.tx(testLender), "legacy"))
+	err = ledger.contract.UnarchiveAsset(ledger.tx(testAdmin), "legacy")
+	require.EqualError(t, err, "archived asset legacy does not record its issuing organization")
+}
+
+func TestKYCVerifiedBorrowers(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+
+	err := ledger.contract.SetKYCVerified(ledger.tx(testLender), testBorrower.id())
+	require.EqualError(t, err, "submitting client is not authorized as compliance: attribute 'role' was not found")
+
+	err = ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "borrower-wallet")
+	require.EqualError(t, err, "borrower "+testBorrower.id()+" is not KYC verified")
+
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), " "+testBorrower.id()))
+	require.NoError(t, ledger.contract.RevokeKYC(ledger.tx(testCompliance), testBorrower.id()))
+	err = ledger.contract.RevokeKYC(ledger.tx(testCompliance), testBorrower.id())
+	require.EqualError(t, err, "identity "+testBorrower.id()+" is not KYC verified")
+
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testBorrower.id()))
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "borrower-wallet"))
+
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, PENDING, asset.State)
+	require.Equal(t, testBorrower.id(), asset.Borrower)
+	require.Empty(t, asset.BorrowerAddress)
+	addresses, err := ledger.contract.ReadPrivateAsset(ledger.tx(testLender), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, "borrower-wallet", addresses.BorrowerAddress)
+}
+
+func TestRestructure(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	err := ledger.contract.ProposeRestructure(ledger.tx(testOutsider), "loan1", `{"amount":800,"startDate":20210101,"endDate":20221231}`)
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	err = ledger.contract.ApplyRestructure(ledger.tx(testAdmin), "loan1")
+	require.EqualError(t, err, "asset loan1 has no pending restructure proposal")
+
+	require.NoError(t, ledger.contract.ProposeRestructure(ledger.tx(testLender), "loan1", `{"amount":800,"startDate":20210101,"endDate":20221231}`))
+
+	err = ledger.contract.ApplyRestructure(ledger.tx(testLender), "loan1")
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+
+	require.NoError(t, ledger.contract.ApplyRestructure(ledger.tx(testAdmin), "loan1"))
+
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, 800, asset.Amount)
+	require.Equal(t, 20221231, asset.EndDate)
+	require.True(t, asset.Restructured)
+	require.Equal(t, 1, asset.RestructureCount)
+
+	err = ledger.contract.ApplyRestructure(ledger.tx(testAdmin), "loan1")
+	require.EqualError(t, err, "asset loan1 has no pending restructure proposal")
+}
+
+func TestProposeRestructureValidatesTerms(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestConfig(configMaxLoanTermDays, 365)
+
+	tests := []struct {
+		terms    string
+		expected string
+	}{
+		{`{"amount":0,"startDate":20210101,"endDate":20211231}`, "amount field must be a positive integer"},
+		{`{"amount":800,"startDate":20210101,"endDate":20210101}`, "end date 20210101 must be after start date 20210101"},
+		{`{"amount":800,"startDate":20210101,"endDate":20211301}`, "invalid end date: date 20211301 is not a valid YYYYMMDD date"},
+		{`{"amount":800,"startDate":20210101,"endDate":20221231}`, "loan term of 729 days exceeds the maximum of 365 days"},
+	}
+
+	for _, test := range tests {
+		err := ledger.contract.ProposeRestructure(ledger.tx(testLender), "loan1", test.terms)
+		require.EqualError(t, err, test.expected, "terms %v", test.terms)
+	}
+}
+
+func TestApplyRestructureRequiresActiveAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	require.NoError(t, ledger.contract.ProposeRestructure(ledger.tx(testLender), "loan1", `{"amount":800,"startDate":20210101,"endDate":20221231}`))
+
+	ledger.putTestAsset(newTestAsset("loan1", REDEEMED))
+
+	err := ledger.contract.ApplyRestructure(ledger.tx(testAdmin), "loan1")
+	require.EqualError(t, err, "asset loan1 is not active and cannot be restructured")
+	require.Equal(t, 1000, ledger.getTestAsset("loan1").Amount)
+}
+
+func TestRedeemAssetRequiresBorrower(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	orphan := newTestAsset("orphan", TRADING)
+	orphan.Borrower = ""
+	ledger.putTestAsset(orphan)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	err := ledger.contract.RedeemAsset(ledger.tx(testLender), "orphan", 20220101, "")
+	require.EqualError(t, err, "asset orphan has no borrower and cannot be redeemed")
+	require.Equal(t, TRADING, ledger.getTestAsset("orphan").State)
+
+	err = ledger.contract.RedeemAsset(ledger.tx(testLender), "loan1", 20211230, "")
+	require.EqualError(t, err, "asset loan1 cannot be redeemed before maturity 20211231")
+
+	require.NoError(t, ledger.contract.RedeemAsset(ledger.tx(testLender), "loan1", 20220101, "settlement"))
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, REDEEMED, asset.State)
+	require.Equal(t, "settlement", asset.SettlementRef)
+}
+
+func TestTransferAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+
+	err := ledger.contract.TransferAsset(ledger.tx(testOutsider), "loan1", testInvestor.id())
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	err = ledger.contract.TransferAsset(ledger.tx(testLender), "issued", testInvestor.id())
+	require.EqualError(t, err, "asset issued is ISSUED, only PENDING or TRADING assets can be transferred")
+
+	require.NoError(t, ledger.contract.TransferAsset(ledger.tx(testLender), "loan1", " "+testInvestor.id()))
+	require.Equal(t, stateEvents[TRADING], ledger.stub.event.EventName)
+
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, testInvestor.id(), asset.Lender)
+	require.Equal(t, TRADING, asset.State)
+	require.Equal(t, []LenderChange{{Lender: testLender.id(), AuthorizedBy: testLender.id(), Timestamp: testStart}}, asset.PreviousLenders)
+
+	err = ledger.contract.TransferAsset(ledger.tx(testLender), "loan1", testOutsider.id())
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+	err = ledger.contract.TransferAsset(ledger.tx(testInvestor), "loan1", testInvestor.id())
+	require.EqualError(t, err, fmt.Sprintf("%v is already the lender of asset loan1", testInvestor.id()))
+}
+
+func TestReassignLender(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	err := ledger.contract.ReassignLender(ledger.tx(testOutsider), "loan1", testInvestor.id())
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+	err = ledger.contract.ReassignLender(ledger.tx(testLender), "loan1", " ")
+	require.EqualError(t, err, "new lender must be a non-empty string")
+	err = ledger.contract.ReassignLender(ledger.tx(testLender), "redeemed", testInvestor.id())
+	require.EqualError(t, err, "asset redeemed is REDEEMED, only active assets can be reassigned")
+	require.Empty(t, ledger.getTestAsset("loan1").PreviousLenders)
+
+	require.NoError(t, ledger.contract.ReassignLender(ledger.tx(testLender), "loan1", testInvestor.id()))
+	ledger.advance(time.Hour)
+	require.NoError(t, ledger.contract.ReassignLender(ledger.tx(testInvestor), "loan1", testOutsider.id()))
+
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, testOutsider.id(), asset.Lender)
+	require.Equal(t, PENDING, asset.State)
+	require.Equal(t, []LenderChange{
+		{Lender: testLender.id(), AuthorizedBy: testLender.id(), Timestamp: testStart},
+		{Lender: testInvestor.id(), AuthorizedBy: testInvestor.id(), Timestamp: testStart.Add(time.Hour)},
+	}, asset.PreviousLenders)
+
+	err = ledger.contract.ReassignLender(ledger.tx(testInvestor), "loan1", testLender.id())
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+	err = ledger.contract.ReassignLender(ledger.tx(testOutsider), "loan1", testOutsider.id())
+	require.EqualError(t, err, fmt.Sprintf("%v is already the lender of asset loan1", testOutsider.id()))
+	require.Len(t, ledger.getTestAsset("loan1").PreviousLenders, 2)
+}
+
+func TestSubmittingClientIdentity(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	clientID, err := submittingClientIdentity(ledger.tx(testLender))
+	require.NoError(t, err)
+	require.Equal(t, testLender.id(), clientID)
+
+	notBase64 := &testIdentity{name: "lender", mspID: testMSPID, encodedID: "%%%"}
+	_, err = submittingClientIdentity(ledger.tx(notBase64))
+	require.EqualError(t, err, "failed to base64 decode clientID: illegal base64 data at input byte 0")
+
+	blank := &testIdentity{name: "lender", mspID: testMSPID, encodedID: "ICAg"}
+	_, err = submittingClientIdentity(ledger.tx(blank))
+	require.EqualError(t, err, "submitting client identity is empty")
+}
+
+func TestSetCollateral(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("covered", TRADING))
+	ledger.putTestAsset(newTestAsset("under", TRADING))
+	ledger.putTestAsset(newTestAsset("unsecured", TRADING))
+
+	err := ledger.contract.SetCollateral(ledger.tx(testOutsider), "covered", `{"description":"warehouse","valuationAmount":2000,"valuationDate":20210101}`)
+	require.EqualError(t, err, "submitting client is not the lender of asset covered")
+
+	err = ledger.contract.SetCollateral(ledger.tx(testLender), "covered", `{"description":"","valuationAmount":2000,"valuationDate":20210101}`)
+	require.EqualError(t, err, "collateral description must be a non-empty string")
+
+	err = ledger.contract.SetCollateral(ledger.tx(testLender), "covered", `{"description":"warehouse","valuationAmount":0,"valuationDate":20210101}`)
+	require.EqualError(t, err, "collateral valuation amount must be a positive integer")
+
+	require.NoError(t, ledger.contract.SetCollateral(ledger.tx(testLender), "covered", `{"description":"warehouse","valuationAmount":2000,"valuationDate":20210101}`))
+	require.NoError(t, ledger.contract.SetCollateral(ledger.tx(testLender), "under", `{"description":"car","valuationAmount":999,"valuationDate":20210101}`))
+
+	require.Equal(t, Collateral{Description: "warehouse", ValuationAmount: 2000, ValuationDate: 20210101}, ledger.getTestAsset("covered").Collateral)
+
+	assets, err := ledger.contract.GetUndercollateralizedAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"under"}, testAssetIDs(assets))
+}
+
+func TestUpdateAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset := newTestAsset("loan1", PENDING)
+	asset.PaymentHashes = []string{"3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"}
+	ledger.putTestAsset(asset)
+
+	err := ledger.contract.UpdateAsset(ledger.tx(testOutsider), "loan1", 20210201, 20220131, 1500)
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	require.NoError(t, ledger.contract.UpdateAsset(ledger.tx(testLender), "loan1", 20210201, 20220131, 1500))
+
+	updated := ledger.getTestAsset("loan1")
+	require.Equal(t, 20210201, updated.StartDate)
+	require.Equal(t, 20220131, updated.EndDate)
+	require.Equal(t, 1500, updated.Amount)
+	require.Equal(t, testLender.id(), updated.Lender)
+	require.Equal(t, testBorrower.id(), updated.Borrower)
+	require.Equal(t, PENDING, updated.State)
+	require.Equal(t, asset.PaymentHashes, updated.PaymentHashes)
+
+	err = ledger.contract.UpdateAsset(ledger.tx(testLender), "missing", 20210201, 20220131, 1500)
+	require.EqualError(t, err, "asset missing does not exist")
+}
+
+func TestUpdateAssetRejectsTradingAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("trading", TRADING))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	err := ledger.contract.UpdateAsset(ledger.tx(testLender), "trading", 20210201, 20220131, 1500)
+	require.EqualError(t, err, "asset trading is TRADING, only ISSUED or PENDING assets can be updated")
+
+	err = ledger.contract.UpdateAsset(ledger.tx(testLender), "redeemed", 20210201, 20220131, 1500)
+	require.EqualError(t, err, "asset redeemed is REDEEMED, only ISSUED or PENDING assets can be updated")
+
+	require.Equal(t, 1000, ledger.getTestAsset("trading").Amount)
+}
+
+func TestChangeState(t *testing.T) {
+	// outcomes of the transitions in the lifecycle; every other pair of states is an invalid transition
+	lifecycle := map[[2]State]string{
+		{ISSUED, PENDING}:   "asset loan1 can only become PENDING by assigning a borrower through AssignBorrower",
+		{PENDING, TRADING}:  "",
+		{PENDING, ISSUED}:   "",
+		{TRADING, REDEEMED}: "",
+	}
+
+	for from := ISSUED; int(from) <= len(stateNames); from++ {
+		for to := ISSUED; int(to) <= len(stateNames); to++ {
+			ledger := newTestLedger(t)
+			asset := newTestAsset("loan1", from)
+			asset.EndDate = 20210531
+			ledger.putTestAsset(asset)
+
+			expected, ok := lifecycle[[2]State{from, to}]
+			if !ok {
+				expected = fmt.Sprintf("invalid transition from %v to %v", from, to)
+			}
+
+			err := ledger.contract.ChangeState(ledger.tx(testLender), "loan1", to.String())
+			if len(expected) != 0 {
+				require.EqualError(t, err, expected, "%v to %v", from, to)
+				require.Equal(t, from, ledger.getTestAsset("loan1").State, "%v to %v", from, to)
+				continue
+			}
+			require.NoError(t, err, "%v to %v", from, to)
+			require.Equal(t, to, ledger.getTestAsset("loan1").State, "%v to %v", from, to)
+			require.Equal(t, stateEvents[to], ledger.stub.event.EventName, "%v to %v", from, to)
+		}
+	}
+}
+
+func TestChangeStateRequiresLender(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+
+	err := ledger.contract.ChangeState(ledger.tx(testBorrower), "loan1", "TRADING")
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	err = ledger.contract.ChangeState(ledger.tx(testLender), "loan1", "OPEN")
+	require.EqualError(t, err, `unknown state "OPEN"`)
+
+	require.Equal(t, PENDING, ledger.getTestAsset("loan1").State)
+}
+
+func TestChangeStateToIssuedRemovesBorrower(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	addresses := AssetPrivateAddresses{BorrowerAddress: "borrower-wallet", InvestorAddress: "investor-wallet"}
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, map[string]interface{}{"asset_addresses": addresses}), "loan1"))
+
+	require.NoError(t, ledger.contract.ChangeState(ledger.tx(testLender), "loan1", "ISSUED"))
+
+	cancelled := ledger.getTestAsset("loan1")
+	require.Equal(t, ISSUED, cancelled.State)
+	require.Empty(t, cancelled.Borrower)
+	stored, err := ledger.contract.ReadPrivateAsset(ledger.tx(testLender), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, &AssetPrivateAddresses{InvestorAddress: "investor-wallet"}, stored)
+
+	// the loan can be assigned to a borrower again
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testInvestor.id()))
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testInvestor.id(), "investor-wallet"))
+	require.Equal(t, testInvestor.id(), ledger.getTestAsset("loan1").Borrower)
+}
+
+func TestChangeStatePersistsState(t *testing.T) {
+	ledger := newTestLedger(t)
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testBorrower.id()))
+	_, err := ledger.issueTestAsset("loan1", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "borrower-wallet"))
+
+	require.NoError(t, ledger.contract.ChangeState(ledger.tx(testLender), "loan1", "TRADING"))
+
+	asset, err := ledger.contract.ReadAsset(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, TRADING, asset.State)
+
+	// the state is stored as its number under currentState
+	compositeKey, err := ledger.stub.CreateCompositeKey(typeAsset, []string{"loan1"})
+	require.NoError(t, err)
+	var stored map[string]interface{}
+	require.NoError(t, json.Unmarshal(ledger.stub.State[compositeKey], &stored))
+	require.Equal(t, float64(TRADING), stored["currentState"])
+}
+
+func TestBulkRevalueCollateral(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, assetID := range []string{"loan1", "loan2"} {
+		asset := newTestAsset(assetID, TRADING)
+		asset.Collateral = Collateral{Description: "warehouse", ValuationAmount: 2000, ValuationDate: 20210101}
+		ledger.putTestAsset(asset)
+	}
+	ledger.putTestAsset(newTestAsset("unsecured", TRADING))
+
+	err := ledger.contract.BulkRevalueCollateral(ledger.tx(testLender), `{"loan1":4000,"unsecured":1000}`, 20210601)
+	require.EqualError(t, err, "asset unsecured has no collateral to revalue")
+	err = ledger.contract.BulkRevalueCollateral(ledger.tx(testLender), `{"loan1":4000,"missing":1000}`, 20210601)
+	require.EqualError(t, err, "asset missing does not exist")
+	require.Equal(t, int64(2000), ledger.getTestAsset("loan1").Collateral.ValuationAmount)
+
+	err = ledger.contract.BulkRevalueCollateral(ledger.tx(testOutsider), `{"loan1":4000}`, 20210601)
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	require.NoError(t, ledger.contract.BulkRevalueCollateral(ledger.tx(testLender), `{"loan1":4000,"loan2":800}`, 20210601))
+
+	ltv, err := ledger.contract.ComputeLTV(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, 0.25, ltv)
+	ltv, err = ledger.contract.ComputeLTV(ledger.tx(testOutsider), "loan2")
+	require.NoError(t, err)
+	require.Equal(t, 1.25, ltv)
+	require.Equal(t, 20210601, ledger.getTestAsset("loan2").Collateral.ValuationDate)
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	ledger := newTestLedger(t)
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testBorrower.id()))
+
+	_, err := ledger.issueTestAsset("loan1", 1000, 20210101, 20210531)
+	require.NoError(t, err)
+	requireAssetEvent(t, ledger, "AssetIssued", "loan1", ISSUED)
+
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "borrower-wallet"))
+	requireAssetEvent(t, ledger, "AssetPending", "loan1", PENDING)
+
+	require.NoError(t, ledger.contract.TransferAsset(ledger.tx(testLender), "loan1", testInvestor.id()))
+	requireAssetEvent(t, ledger, "AssetTraded", "loan1", TRADING)
+
+	require.NoError(t, ledger.contract.RedeemAsset(ledger.tx(testInvestor), "loan1", 0, ""))
+	requireAssetEvent(t, ledger, "AssetRedeemed", "loan1", REDEEMED)
+
+	require.NoError(t, ledger.contract.DeleteAsset(ledger.tx(testInvestor), "loan1"))
+	require.Equal(t, "AssetDeleted", ledger.stub.event.EventName)
+	require.Equal(t, "loan1", string(ledger.stub.event.Payload))
+}
+
+// requireAssetEvent checks that the last transaction emitted eventName with the asset JSON as payload.
+func requireAssetEvent(t *testing.T, ledger *testLedger, eventName string, assetID string, state State) {
+	require.NotNil(t, ledger.stub.event, "no event emitted")
+	require.Equal(t, eventName, ledger.stub.event.EventName)
+
+	var asset Asset
+	require.NoError(t, json.Unmarshal(ledger.stub.event.Payload, &asset))
+	require.Equal(t, assetID, asset.ID)
+	require.Equal(t, state, asset.State)
+}
+
+func TestRedeemAssetIdempotent(t *testing.T) {
+	ledger := newTestLedger(t)
+	asset := newTestAsset("loan1", TRADING)
+	asset.EndDate = 20210531
+	ledger.putTestAsset(asset)
+
+	err := ledger.contract.RedeemAssetIdempotent(ledger.tx(testLender), "loan1", "")
+	require.EqualError(t, err, "redemption hash must be a non-empty string")
+
+	require.NoError(t, ledger.contract.RedeemAssetIdempotent(ledger.tx(testLender), "loan1", "hash1"))
+	redeemed := ledger.getTestAsset("loan1")
+	require.Equal(t, REDEEMED, redeemed.State)
+	require.Equal(t, "hash1", redeemed.RedemptionHash)
+
+	// replaying the redemption leaves the asset untouched
+	require.NoError(t, ledger.contract.RedeemAssetIdempotent(ledger.tx(testLender), "loan1", "hash1"))
+	require.Equal(t, redeemed, ledger.getTestAsset("loan1"))
+
+	err = ledger.contract.RedeemAssetIdempotent(ledger.tx(testLender), "loan1", "hash2")
+	require.EqualError(t, err, "asset loan1 was already redeemed with a different hash")
+}
+
+func TestIssueAssetValidatesTerms(t *testing.T) {
+	tests := []struct {
+		amount   int
+		start    int
+		end      int
+		expected string
+	}{
+		{0, 20210101, 20211231, "amount field must be a positive integer"},
+		{-5, 20210101, 20211231, "amount field must be a positive integer"},
+		{1000, 0, 20211231, "start date must be a positive integer"},
+		{1000, 20210101, -1, "end date must be a positive integer"},
+		{1000, 20211231, 20210101, "end date 20210101 must be after start date 20211231"},
+		{1000, 20210101, 20210101, "end date 20210101 must be after start date 20210101"},
+		{1000, 20210229, 20211231, "invalid start date: date 20210229 is not a valid YYYYMMDD date"},
+		{1000, 20210101, 20211232, "invalid end date: date 20211232 is not a valid YYYYMMDD date"},
+	}
+
+	ledger := newTestLedger(t)
+	for _, test := range tests {
+		_, err := ledger.issueTestAsset("loan1", test.amount, test.start, test.end)
+		require.EqualError(t, err, test.expected, "amount %v, start %v, end %v", test.amount, test.start, test.end)
+	}
+
+	asset, err := ledger.issueTestAsset("loan1", 1000, 20200229, 20211231)
+	require.NoError(t, err)
+	require.Equal(t, ISSUED, asset.State)
+	require.Equal(t, testLender.id(), ledger.getTestAsset("loan1").Lender)
+}
+
+func TestAssignBorrower(t *testing.T) {
+	ledger := newTestLedger(t)
+	require.NoError(t, ledger.contract.SetKYCVerified(ledger.tx(testCompliance), testBorrower.id()))
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+
+	err := ledger.contract.AssignBorrower(ledger.tx(testOutsider), "loan1", testBorrower.id(), "borrower-wallet")
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	err = ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", " ", "borrower-wallet")
+	require.EqualError(t, err, "borrower must be a non-empty string")
+	err = ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "")
+	require.EqualError(t, err, "borrower address must be a non-empty string")
+
+	require.NoError(t, ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "borrower-wallet"))
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, PENDING, asset.State)
+	require.Equal(t, testBorrower.id(), asset.Borrower)
+
+	err = ledger.contract.AssignBorrower(ledger.tx(testLender), "loan1", testBorrower.id(), "borrower-wallet")
+	require.EqualError(t, err, "asset loan1 already has a borrower assigned")
+
+	noBorrower := newTestAsset("trading", TRADING)
+	noBorrower.Borrower = ""
+	ledger.putTestAsset(noBorrower)
+	err = ledger.contract.AssignBorrower(ledger.tx(testLender), "trading", testBorrower.id(), "borrower-wallet")
+	require.EqualError(t, err, "asset trading is TRADING, only ISSUED assets can be assigned a borrower")
+}
+
+func TestRecordPayment(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	paymentHash := "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+
+	count, err := ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", paymentHash, "USD")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	_, err = ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", "3A6EB0790F39AC87C94F3856B2DD2C5D110E6811602261A9A923D3BB23ADC8B7", "USD")
+	require.EqualError(t, err, "payment 3A6EB0790F39AC87C94F3856B2DD2C5D110E6811602261A9A923D3BB23ADC8B7 has already been recorded for asset loan1")
+
+	for _, malformed := range []string{"", "3a6eb079", paymentHash[:63] + "z"} {
+		_, err = ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", malformed, "USD")
+		require.EqualError(t, err, "payment hash must be a 64 character hex string", "payment hash %q", malformed)
+	}
+
+	_, err = ledger.contract.RecordPayment(ledger.tx(testBorrower), "issued", paymentHash, "USD")
+	require.EqualError(t, err, "asset issued is ISSUED, payments can only be recorded for PENDING or TRADING assets")
+
+	count, err = ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", "be5e5d5a2d34b1bbd5bbc9dba6ecf4b0e0f3a3f1c5a81bd06d4ca2e4d0cb2a1e", "")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.Len(t, ledger.getTestAsset("loan1").PaymentHashes, 2)
+}
+
+func TestRecordPaymentCurrency(t *testing.T) {
+	ledger := newTestLedger(t)
+	euroLoan := newTestAsset("loan1", TRADING)
+	euroLoan.Currency = "EUR"
+	ledger.putTestAsset(euroLoan)
+	paymentHash := "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+
+	_, err := ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", paymentHash, "USD")
+	require.EqualError(t, err, "payment currency USD does not match the currency EUR of asset loan1")
+	require.Empty(t, ledger.getTestAsset("loan1").PaymentHashes)
+
+	_, err = ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", paymentHash, "XYZ")
+	require.EqualError(t, err, `currency "XYZ" is not supported`)
+
+	count, err := ledger.contract.RecordPayment(ledger.tx(testBorrower), "loan1", paymentHash, "EUR")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestSetInvestorPubKey(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	pubKeyHex := testPublicKeyHex(t, testSigningKey(t, testKeyScalar))
+
+	err := ledger.contract.SetInvestorPubKey(ledger.tx(testLender), "loan1", "")
+	require.EqualError(t, err, "public key must be a non-empty hex string")
+
+	err = ledger.contract.SetInvestorPubKey(ledger.tx(testLender), "loan1", "not hex")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "public key must be a hex string")
+
+	err = ledger.contract.SetInvestorPubKey(ledger.tx(testLender), "loan1", "3059")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to parse public key")
+
+	p384Key := testCurveKey(t, elliptic.P384(), testKeyScalar)
+	err = ledger.contract.SetInvestorPubKey(ledger.tx(testLender), "loan1", testPublicKeyHex(t, p384Key))
+	require.EqualError(t, err, "public key is on curve P-384, only P-256 keys are supported")
+
+	err = ledger.contract.SetInvestorPubKey(ledger.tx(testOutsider), "loan1", pubKeyHex)
+	require.Error(t, err)
+	require.Empty(t, ledger.getTestAsset("loan1").InvestorPubKey)
+
+	require.NoError(t, ledger.contract.SetInvestorPubKey(ledger.tx(testLender), "loan1", strings.ToUpper(pubKeyHex)))
+	require.Equal(t, pubKeyHex, ledger.getTestAsset("loan1").InvestorPubKey)
+}
+
+func TestRecordSignedPayment(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	key := testSigningKey(t, testKeyScalar)
+	pubKeyHex := testPublicKeyHex(t, key)
+	paymentHash := "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+	digest, err := decodePaymentHash(paymentHash)
+	require.NoError(t, err)
+	signature := testSign(t, key, digest)
+
+	_, err = ledger.contract.RecordSignedPayment(ledger.tx(testInvestor), "loan1", paymentHash, signature, pubKeyHex)
+	require.EqualError(t, err, "asset loan1 has no investor public key")
+
+	require.NoError(t, ledger.contract.SetInvestorPubKey(ledger.tx(testLender), "loan1", pubKeyHex))
+
+	otherKey := testSigningKey(t, testOtherKeyScalar)
+	_, err = ledger.contract.RecordSignedPayment(ledger.tx(testInvestor), "loan1", paymentHash, testSign(t, otherKey, digest), testPublicKeyHex(t, otherKey))
+	require.EqualError(t, err, "public key does not match the investor key of asset loan1")
+
+	_, err = ledger.contract.RecordSignedPayment(ledger.tx(testInvestor), "loan1", paymentHash, testSign(t, otherKey, digest), pubKeyHex)
+	require.EqualError(t, err, "signature over payment "+paymentHash+" is invalid")
+
+	otherDigest, err := decodePaymentHash("be5e5d5a2d34b1bbd5bbc9dba6ecf4b0e0f3a3f1c5a81bd06d4ca2e4d0cb2a1e")
+	require.NoError(t, err)
+	_, err = ledger.contract.RecordSignedPayment(ledger.tx(testInvestor), "loan1", paymentHash, testSign(t, key, otherDigest), pubKeyHex)
+	require.EqualError(t, err, "signature over payment "+paymentHash+" is invalid")
+	require.Empty(t, ledger.getTestAsset("loan1").PaymentHashes)
+
+	count, err := ledger.contract.RecordSignedPayment(ledger.tx(testInvestor), "loan1", paymentHash, signature, pubKeyHex)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, []string{paymentHash}, ledger.getTestAsset("loan1").PaymentHashes)
+}
+
+func TestVerifySignatureRejectsOtherCurves(t *testing.T) {
+	key := testCurveKey(t, elliptic.P384(), testKeyScalar)
+	digest, err := decodePaymentHash("3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7")
+	require.NoError(t, err)
+
+	_, err = verifySignature(testPublicKeyHex(t, key), digest, testSign(t, key, digest))
+	require.EqualError(t, err, "public key is on curve P-384, only P-256 keys are supported")
+}
+
+func TestRedeemAssetSettlementRef(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestAsset(newTestAsset("loan2", TRADING))
+
+	err := ledger.contract.RedeemAsset(ledger.tx(testLender), "loan1", 20220101, "  ")
+	require.EqualError(t, err, "settlement reference must not be blank")
+
+	require.NoError(t, ledger.contract.RedeemAsset(ledger.tx(testLender), "loan1", 20220101, "SWIFT-0042"))
+	require.Equal(t, "SWIFT-0042", ledger.getTestAsset("loan1").SettlementRef)
+
+	require.NoError(t, ledger.contract.RedeemAsset(ledger.tx(testLender), "loan2", 20220101, ""))
+	require.Empty(t, ledger.getTestAsset("loan2").SettlementRef)
+}
+
+func TestDeleteAndUpdateRequireLender(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+
+	for _, identity := range []*testIdentity{testBorrower, testOutsider, testAdmin} {
+		err := ledger.contract.DeleteAsset(ledger.tx(identity), "loan1")
+		require.EqualError(t, err, "submitting client is not the lender of asset loan1", "caller %v", identity.name)
+		err = ledger.contract.UpdateAsset(ledger.tx(identity), "loan1", 20210101, 20211231, 5000)
+		require.EqualError(t, err, "submitting client is not the lender of asset loan1", "caller %v", identity.name)
+	}
+	require.Equal(t, 1000, ledger.getTestAsset("loan1").Amount)
+
+	require.NoError(t, ledger.contract.UpdateAsset(ledger.tx(testLender), "loan1", 20210101, 20211231, 5000))
+	require.Equal(t, 5000, ledger.getTestAsset("loan1").Amount)
+
+	addresses := AssetPrivateAddresses{BorrowerAddress: "borrower-wallet"}
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, map[string]interface{}{"asset_addresses": addresses}), "loan1"))
+	require.NoError(t, ledger.contract.DeleteAsset(ledger.tx(testLender), "loan1"))
+	exists, err := assetExists(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Nil(t, ledger.stub.PvtState[assetLoanCollection]["loan1"])
+}
+
+func TestRedeemAssetAfterMaturity(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("onMaturity", TRADING))
+	ledger.putTestAsset(newTestAsset("early", TRADING))
+	ledger.putTestAsset(newTestAsset("pending", PENDING))
+
+	err := ledger.contract.RedeemAsset(ledger.tx(testLender), "early", 20211230, "")
+	require.EqualError(t, err, "asset early cannot be redeemed before maturity 20211231")
+
+	// without a date the transaction date is used, which is before maturity
+	err = ledger.contract.RedeemAsset(ledger.tx(testLender), "early", 0, "")
+	require.EqualError(t, err, "asset early cannot be redeemed before maturity 20211231")
+
+	err = ledger.contract.RedeemAsset(ledger.tx(testLender), "pending", 20220101, "")
+	require.EqualError(t, err, "asset pending is PENDING, only TRADING assets can be redeemed")
+
+	require.NoError(t, ledger.contract.RedeemAsset(ledger.tx(testLender), "onMaturity", 20211231, ""))
+	require.Equal(t, REDEEMED, ledger.getTestAsset("onMaturity").State)
+
+	err = ledger.contract.RedeemAsset(ledger.tx(testLender), "early", 20211301, "")
+	require.EqualError(t, err, "date 20211301 is not a valid YYYYMMDD date")
+}
+
+func TestCreateAssets(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	assetIDs, err := ledger.contract.CreateAssets(ledger.tx(testLender), `[
+		{"assetID":"loan1","amount":1000,"startDate":20210101,"endDate":20220101,"region":"EU"},
+		{"assetID":"loan2","amount":2000,"startDate":20210101,"endDate":20220101,"currency":"EUR"}
+	]`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"loan1", "loan2"}, assetIDs)
+
+	asset := ledger.getTestAsset("loan2")
+	require.Equal(t, ISSUED, asset.State)
+	require.Equal(t, testLender.id(), asset.Lender)
+	require.Equal(t, "EUR", asset.Currency)
+	require.Equal(t, testMSPID, asset.IssuerMSP)
+	require.Equal(t, defaultCurrency, ledger.getTestAsset("loan1").Currency)
+}
+
+func TestCreateAssetsRunsIssueChecks(t *testing.T) {
+	ledger := newTestLedger(t)
+	definitions := `[
+		{"assetID":"loan1","amount":1000,"startDate":20210101,"endDate":20220101,"collateral":{"description":"warehouse","valuationAmount":1500,"valuationDate":20210101}},
+		{"assetID":"loan2","amount":1000,"startDate":20210101,"endDate":20220101}
+	]`
+
+	require.NoError(t, ledger.contract.SetBlackoutDates(ledger.tx(testAdmin), []int{20210601}))
+	_, err := ledger.contract.CreateAssets(ledger.tx(testLender), definitions)
+	require.EqualError(t, err, "asset loan1: assets cannot be issued on blackout date 20210601")
+	require.NoError(t, ledger.contract.SetBlackoutDates(ledger.tx(testAdmin), []int{}))
+
+	require.NoError(t, ledger.contract.SetMinCollateralCoverage(ledger.tx(testAdmin), 1.5))
+	_, err = ledger.contract.CreateAssets(ledger.tx(testLender), definitions)
+	require.EqualError(t, err, "asset loan2: asset loan2 requires collateral covering at least 1.5 times the amount")
+
+	exists, err := assetExists(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestCreateAssetsRejectsWholeBatch(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("existing", ISSUED))
+
+	tests := []struct {
+		assetsJSON string
+		expected   string
+	}{
+		{`[]`, "at least one asset must be given"},
+		{`[{"assetID":"loan1","amount":1000,"startDate":20210101,"endDate":20220101},{"assetID":"loan2","amount":0,"startDate":20210101,"endDate":20220101}]`, "asset loan2: amount field must be a positive integer"},
+		{`[{"assetID":"loan1","amount":1000,"startDate":20210101,"endDate":20220101},{"assetID":"existing","amount":1000,"startDate":20210101,"endDate":20220101}]`, "asset existing: asset with id: existing already exist"},
+		{`[{"assetID":"loan1","amount":1000,"startDate":20210101,"endDate":20220101},{"assetID":"loan1","amount":1000,"startDate":20210101,"endDate":20220101}]`, "asset loan1 is given more than once"},
+	}
+
+	for _, test := range tests {
+		_, err := ledger.contract.CreateAssets(ledger.tx(testLender), test.assetsJSON)
+		require.EqualError(t, err, test.expected, "assets %v", test.assetsJSON)
+
+		exists, err := assetExists(ledger.tx(testOutsider), "loan1")
+		require.NoError(t, err)
+		require.False(t, exists, "assets %v", test.assetsJSON)
+	}
+}
+
+func TestWriteDown(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	err := ledger.contract.WriteDown(ledger.tx(testLender), "loan1", 1001, "default")
+	require.EqualError(t, err, "write-down of 1001 exceeds the amount 1000 of asset loan1")
+	err = ledger.contract.WriteDown(ledger.tx(testLender), "loan1", 100, "")
+	require.EqualError(t, err, "reason must be a non-empty string")
+	err = ledger.contract.WriteDown(ledger.tx(testOutsider), "loan1", 100, "default")
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	require.NoError(t, ledger.contract.WriteDown(ledger.tx(testLender), "loan1", 400, "partial default"))
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, 600, asset.Amount)
+	require.Equal(t, TRADING, asset.State)
+	require.Nil(t, ledger.stub.event)
+
+	require.NoError(t, ledger.contract.WriteDown(ledger.tx(testLender), "loan1", 600, "default"))
+	asset = ledger.getTestAsset("loan1")
+	require.Zero(t, asset.Amount)
+	require.Equal(t, WRITTEN_OFF, asset.State)
+	requireAssetEvent(t, ledger, "AssetWrittenOff", "loan1", WRITTEN_OFF)
+
+	err = ledger.contract.WriteDown(ledger.tx(testLender), "loan1", 1, "default")
+	require.EqualError(t, err, "asset loan1 is WRITTEN_OFF, only active assets can be written down")
+}
+
+func TestCreatePrivateAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	asset := newTestAsset("loan1", PENDING)
+	asset.BorrowerAddress = "legacy-borrower-wallet"
+	asset.InvestorAddress = "legacy-investor-wallet"
+	ledger.putTestAsset(asset)
+
+	err := ledger.contract.CreatePrivateAsset(ledger.tx(testLender), "loan1")
+	require.EqualError(t, err, "asset_addresses not found in the transient map")
+	err = ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, map[string]interface{}{"asset_addresses": AssetPrivateAddresses{}}), "loan1")
+	require.EqualError(t, err, "at least one address must be given")
+
+	transient := map[string]interface{}{"asset_addresses": AssetPrivateAddresses{BorrowerAddress: "borrower-wallet"}}
+	err = ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testOutsider, transient), "loan1")
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+	_, err = ledger.contract.ReadPrivateAsset(ledger.tx(testLender), "loan1")
+	require.EqualError(t, err, "private addresses of asset loan1 do not exist in collection assetLoanCollection")
+
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, transient), "loan1"))
+	stored := ledger.getTestAsset("loan1")
+	require.Empty(t, stored.BorrowerAddress)
+	require.Empty(t, stored.InvestorAddress)
+
+	// an address left empty keeps its stored value
+	transient = map[string]interface{}{"asset_addresses": AssetPrivateAddresses{InvestorAddress: "investor-wallet"}}
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, transient), "loan1"))
+	addresses, err := ledger.contract.ReadPrivateAsset(ledger.tx(testLender), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, &AssetPrivateAddresses{BorrowerAddress: "borrower-wallet", InvestorAddress: "investor-wallet"}, addresses)
+}
+
+func TestBeginTrading(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+
+	for _, identity := range []*testIdentity{testBorrower, testOutsider, testAdmin} {
+		err := ledger.contract.BeginTrading(ledger.tx(identity), "loan1")
+		require.EqualError(t, err, "submitting client is not the lender of asset loan1", "caller %v", identity.name)
+	}
+	require.Equal(t, PENDING, ledger.getTestAsset("loan1").State)
+
+	require.NoError(t, ledger.contract.BeginTrading(ledger.tx(testLender), "loan1"))
+	require.Equal(t, TRADING, ledger.getTestAsset("loan1").State)
+	requireAssetEvent(t, ledger, "AssetTraded", "loan1", TRADING)
+
+	err := ledger.contract.BeginTrading(ledger.tx(testLender), "issued")
+	require.EqualError(t, err, "asset issued is ISSUED, only PENDING assets can begin trading")
+}
+
+func TestIssueAssetReturnsStoredAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	asset, err := ledger.issueTestAsset("loan1", 1000, 20210101, 20211231)
+	require.NoError(t, err)
+	require.Equal(t, testLender.id(), asset.Lender)
+	require.Equal(t, ISSUED, asset.State)
+	require.Equal(t, "USD", asset.Currency)
+	require.Equal(t, "Org1MSP", asset.IssuerMSP)
+	require.Equal(t, ledger.getTestAsset("loan1"), asset)
+
+	_, err = ledger.issueTestAsset("loan1", 1000, 20210101, 20211231)
+	require.EqualError(t, err, "asset with id: loan1 already exist")
+}
+
+func TestIssueAssetRejectsEmptyIdentity(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	blank := &testIdentity{name: "lender", mspID: testMSPID, encodedID: "ICAg"}
+	ctx := ledger.txWithTransient(blank, map[string]interface{}{"loan1": AssetPrivate{SecretMessage: "terms of loan1"}})
+	_, err := ledger.contract.IssueAsset(ctx, "loan1", 1000, 20210101, 20211231, testRegion, "")
+	require.EqualError(t, err, "failed to get verified OrgID: submitting client identity is empty")
+
+	exists, err := assetExists(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestValidateAssetID(t *testing.T) {
+	tests := []struct {
+		assetID  string
+		expected string
+	}{
+		{"", "assetID must be a non-empty string"},
+		{strings.Repeat("a", maxAssetIDLength+1), "assetID must not be longer than 64 bytes"},
+		{"loan\x001", "assetID must not contain U+0000"},
+	}
+
+	ledger := newTestLedger(t)
+	for _, test := range tests {
+		require.EqualError(t, validateAssetID(test.assetID), test.expected, "assetID %q", test.assetID)
+
+		_, err := ledger.issueTestAsset(test.assetID, 1000, 20210101, 20211231)
+		require.EqualError(t, err, test.expected, "IssueAsset %q", test.assetID)
+		_, err = ledger.contract.ReadAsset(ledger.tx(testOutsider), test.assetID)
+		require.EqualError(t, err, test.expected, "ReadAsset %q", test.assetID)
+		err = ledger.contract.DeleteAsset(ledger.tx(testLender), test.assetID)
+		require.EqualError(t, err, test.expected, "DeleteAsset %q", test.assetID)
+		_, err = assetExists(ledger.tx(testOutsider), test.assetID)
+		require.EqualError(t, err, test.expected, "assetExists %q", test.assetID)
+	}
+
+	require.NoError(t, validateAssetID(strings.Repeat("a", maxAssetIDLength)))
+}
+
+func TestSplitAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+	ledger.putTestAsset(newTestAsset("pending", PENDING))
+	ledger.putTestAsset(newTestAsset("taken", TRADING))
+
+	require.NoError(t, ledger.contract.SplitAsset(ledger.tx(testLender), "loan1", "loan1-a", 250))
+	require.Equal(t, 750, ledger.getTestAsset("loan1").Amount)
+	tranche := ledger.getTestAsset("loan1-a")
+	require.Equal(t, 250, tranche.Amount)
+	require.Equal(t, TRADING, tranche.State)
+	require.Equal(t, testLender.id(), tranche.Lender)
+	require.Equal(t, testBorrower.id(), tranche.Borrower)
+	require.Equal(t, 20210101, tranche.StartDate)
+	require.Equal(t, 20211231, tranche.EndDate)
+
+	err := ledger.contract.SplitAsset(ledger.tx(testLender), "loan1", "loan1-b", 750)
+	require.EqualError(t, err, "split amount must be between 0 and the asset amount 750")
+	err = ledger.contract.SplitAsset(ledger.tx(testLender), "loan1", "taken", 100)
+	require.EqualError(t, err, "asset with id: taken already exist")
+	require.Equal(t, 1000, ledger.getTestAsset("taken").Amount)
+	require.Equal(t, 750, ledger.getTestAsset("loan1").Amount)
+
+	err = ledger.contract.SplitAsset(ledger.tx(testLender), "pending", "pending-a", 100)
+	require.EqualError(t, err, "asset pending is PENDING, only TRADING assets can be split")
+	err = ledger.contract.SplitAsset(ledger.tx(testOutsider), "loan1", "loan1-b", 100)
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+}
+
+func TestDeleteAllAssetsForLender(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("lender1", ISSUED))
+	ledger.putTestAsset(newTestAsset("lender2", TRADING))
+	other := newTestAsset("other", TRADING)
+	other.Lender = testInvestor.id()
+	ledger.putTestAsset(other)
+	addresses := AssetPrivateAddresses{BorrowerAddress: "borrower-wallet", InvestorAddress: "investor-wallet"}
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testLender, map[string]interface{}{"asset_addresses": addresses}), "lender2"))
+	require.NoError(t, ledger.contract.CreatePrivateAsset(ledger.txWithTransient(testInvestor, map[string]interface{}{"asset_addresses": addresses}), "other"))
+
+	deleted, err := ledger.contract.DeleteAllAssetsForLender(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+	require.Nil(t, ledger.stub.event)
+
+	deleted, err = ledger.contract.DeleteAllAssetsForLender(ledger.tx(testLender))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	require.Equal(t, "AssetsDeleted", ledger.stub.event.EventName)
+	require.JSONEq(t, `["lender1","lender2"]`, string(ledger.stub.event.Payload))
+
+	assets, err := getAllAssets(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, []string{"other"}, testAssetIDs(assets))
+	require.Nil(t, ledger.stub.PvtState[assetLoanCollection]["lender2"])
+	require.NotNil(t, ledger.stub.PvtState[assetLoanCollection]["other"])
+}
+
+func TestTradeAsset(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", PENDING))
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	legacy := newTestAsset("legacy", TRADING)
+	legacy.InvestorAddress = "legacy-investor-wallet"
+	ledger.putTestAsset(legacy)
+
+	err := ledger.contract.TradeAsset(ledger.tx(testOutsider), "loan1", testInvestor.id(), "investor-wallet")
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+	err = ledger.contract.TradeAsset(ledger.tx(testLender), "loan1", testInvestor.id(), "")
+	require.EqualError(t, err, "investor address must be a non-empty string")
+	err = ledger.contract.TradeAsset(ledger.tx(testLender), "issued", testInvestor.id(), "investor-wallet")
+	require.EqualError(t, err, "asset issued is ISSUED, only PENDING or TRADING assets can be traded")
+	err = ledger.contract.TradeAsset(ledger.tx(testLender), "loan1", testLender.id(), "investor-wallet")
+	require.EqualError(t, err, fmt.Sprintf("%v is already the lender of asset loan1", testLender.id()))
+
+	require.NoError(t, ledger.contract.TradeAsset(ledger.tx(testLender), "loan1", testInvestor.id(), "investor-wallet"))
+	requireAssetEvent(t, ledger, "AssetTraded", "loan1", TRADING)
+
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, testInvestor.id(), asset.Lender)
+	require.Equal(t, TRADING, asset.State)
+	require.Equal(t, []LenderChange{{Lender: testLender.id(), AuthorizedBy: testLender.id(), Timestamp: testStart}}, asset.PreviousLenders)
+	require.Empty(t, asset.InvestorAddress)
+	addresses, err := ledger.contract.ReadPrivateAsset(ledger.tx(testInvestor), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, &AssetPrivateAddresses{InvestorAddress: "investor-wallet"}, addresses)
+
+	// an investor address held in the public state of an older asset is moved to the private collection
+	require.NoError(t, ledger.contract.TradeAsset(ledger.tx(testLender), "legacy", testInvestor.id(), "investor-wallet"))
+	require.Empty(t, ledger.getTestAsset("legacy").InvestorAddress)
+}
+
+func TestUpdateAssetWithVersion(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", ISSUED))
+
+	readVersion := ledger.getTestAsset("loan1").Version
+
+	// another client updates the asset after it was read
+	require.NoError(t, ledger.contract.UpdateAsset(ledger.tx(testLender), "loan1", 20210101, 20211231, 1500))
+	require.Equal(t, readVersion+1, ledger.getTestAsset("loan1").Version)
+
+	err := ledger.contract.UpdateAssetWithVersion(ledger.tx(testLender), "loan1", readVersion, 20210101, 20211231, 2000)
+	require.EqualError(t, err, fmt.Sprintf("version conflict on asset loan1: expected version %v, stored version is %v", readVersion, readVersion+1))
+	require.Equal(t, 1500, ledger.getTestAsset("loan1").Amount)
+
+	require.NoError(t, ledger.contract.UpdateAssetWithVersion(ledger.tx(testLender), "loan1", readVersion+1, 20210101, 20211231, 2000))
+	updated := ledger.getTestAsset("loan1")
+	require.Equal(t, 2000, updated.Amount)
+	require.Equal(t, readVersion+2, updated.Version)
+
+	err = ledger.contract.UpdateAssetWithVersion(ledger.tx(testOutsider), "loan1", readVersion+2, 20210101, 20211231, 3000)
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+}
+
+func TestCreateAssetFromJSON(t *testing.T) {
+	ledger := newTestLedger(t)
+	transient := map[string]interface{}{"loan1": AssetPrivate{SecretMessage: "terms of loan1"}}
+
+	err := ledger.contract.CreateAssetFromJSON(ledger.txWithTransient(testLender, transient), `{"assetID":"loan1",`)
+	require.EqualError(t, err, "failed to unmarshal JSON: unexpected end of JSON input")
+	err = ledger.contract.CreateAssetFromJSON(ledger.txWithTransient(testLender, transient), `{"assetID":"loan1","startDate":20210101}`)
+	require.EqualError(t, err, "missing required fields: endDate, amount")
+	err = ledger.contract.CreateAssetFromJSON(ledger.txWithTransient(testLender, transient), `{}`)
+	require.EqualError(t, err, "missing required fields: assetID, startDate, endDate, amount")
+
+	// the request goes through the checks of IssueAsset
+	err = ledger.contract.CreateAssetFromJSON(ledger.txWithTransient(testLender, transient), `{"assetID":"loan1","startDate":20211231,"endDate":20210101,"amount":1000}`)
+	require.EqualError(t, err, "end date 20210101 must be after start date 20211231")
+
+	err = ledger.contract.CreateAssetFromJSON(ledger.txWithTransient(testLender, transient), `{"assetID":"loan1","startDate":20210101,"endDate":20211231,"amount":1000,"region":"EU","currency":"EUR"}`)
+	require.NoError(t, err)
+	asset := ledger.getTestAsset("loan1")
+	require.Equal(t, ISSUED, asset.State)
+	require.Equal(t, testLender.id(), asset.Lender)
+	require.Equal(t, 1000, asset.Amount)
+	require.Equal(t, 20210101, asset.StartDate)
+	require.Equal(t, 20211231, asset.EndDate)
+	require.Equal(t, "EU", asset.Region)
+	require.Equal(t, "EUR", asset.Currency)
+}
+
+func TestSetAssetEndorsingOrgs(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	orgs, err := ledger.contract.GetAssetEndorsingOrgs(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Empty(t, orgs)
+
+	err = ledger.contract.SetAssetEndorsingOrgs(ledger.tx(testLender), "loan1", []string{})
+	require.EqualError(t, err, "at least one endorsing organization must be given")
+	err = ledger.contract.SetAssetEndorsingOrgs(ledger.tx(testLender), "loan1", []string{"Org1MSP", ""})
+	require.EqualError(t, err, "endorsing organization MSP IDs must be non-empty strings")
+	err = ledger.contract.SetAssetEndorsingOrgs(ledger.tx(testOutsider), "loan1", []string{"Org1MSP"})
+	require.EqualError(t, err, "submitting client is not the lender of asset loan1")
+
+	require.NoError(t, ledger.contract.SetAssetEndorsingOrgs(ledger.tx(testLender), "loan1", []string{"Org2MSP", "Org1MSP"}))
+	require.ElementsMatch(t, []string{"Org1MSP", "Org2MSP"}, ledger.endorsingOrgs("loan1"))
+
+	orgs, err = ledger.contract.GetAssetEndorsingOrgs(ledger.tx(testOutsider), "loan1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"Org1MSP", "Org2MSP"}, orgs)
+
+	_, err = ledger.contract.GetAssetEndorsingOrgs(ledger.tx(testOutsider), "missing")
+	require.EqualError(t, err, "asset missing does not exist")
+}
+
+func TestRestoreAssetFromHistory(t *testing.T) {
+	ledger := newTestLedger(t)
+	asset := newTestAsset("loan1", ISSUED)
+	asset.IssuerMSP = "Org2MSP"
+	require.NoError(t, createAsset(ledger.tx(testLender), &asset, asset.IssuerMSP))
+	createdTxID := ledger.stub.TxID
+	require.NoError(t, ledger.contract.UpdateAsset(ledger.tx(testLender), "loan1", 20210101, 20211231, 1500))
+	updatedTxID := ledger.stub.TxID
+	require.NoError(t, ledger.contract.UpdateAsset(ledger.tx(testLender), "loan1", 20210101, 20211231, 2000))
+
+	err := ledger.contract.RestoreAssetFromHistory(ledger.tx(testLender), "loan1", createdTxID)
+	require.EqualError(t, err, "submitting client is not authorized as admin: attribute 'role' was not found")
+	err = ledger.contract.RestoreAssetFromHistory(ledger.tx(testAdmin), "loan1", "unknown")
+	require.EqualError(t, err, "transaction unknown is not in the history of asset loan1")
+
+	require.NoError(t, ledger.contract.RestoreAssetFromHistory(ledger.tx(testAdmin), "loan1", createdTxID))
+	restored := ledger.getTestAsset("loan1")
+	require.Equal(t, 1000, restored.Amount)
+	require.Equal(t, 4, restored.Version)
+	requireAssetEvent(t, ledger, "AssetIssued", "loan1", ISSUED)
+
+	require.NoError(t, ledger.contract.DeleteAsset(ledger.tx(testLender), "loan1"))
+	deletedTxID := ledger.stub.TxID
+	err = ledger.contract.RestoreAssetFromHistory(ledger.tx(testAdmin), "loan1", deletedTxID)
+	require.EqualError(t, err, fmt.Sprintf("transaction %v deleted asset loan1, there is no value to restore", deletedTxID))
+
+	// a deleted asset is recreated, endorsed by its issuing organization
+	require.NoError(t, ledger.contract.RestoreAssetFromHistory(ledger.tx(testAdmin), "loan1", updatedTxID))
+	recreated := ledger.getTestAsset("loan1")
+	require.Equal(t, 1500, recreated.Amount)
+	require.Equal(t, 5, recreated.Version)
+	require.Equal(t, []string{"Org2MSP"}, ledger.endorsingOrgs("loan1"))
+
+	legacy := newTestAsset("legacy", ISSUED)
+	legacy.IssuerMSP = ""
+	ledger.putTestAsset(legacy)
+	legacyTxID := ledger.stub.TxID
+	require.NoError(t, ledger.contract.DeleteAsset(ledger.tx(testLender), "legacy"))
+	err = ledger.contract.RestoreAssetFromHistory(ledger.tx(testAdmin), "legacy", legacyTxID)
+	require.EqualError(t, err, "deleted asset legacy does not record its issuing organization")
+}
+
+func TestIssueAssetCurrency(t *testing.T) {
+	ledger := newTestLedger(t)
+	issue := func(assetID string, currency string) (*Asset, error) {
+		ctx := ledger.txWithTransient(testLender, map[string]interface{}{assetID: AssetPrivate{SecretMessage: "terms of " + assetID}})
+		return ledger.contract.IssueAsset(ctx, assetID, 1000, 20210101, 20211231, testRegion, currency)
+	}
+
+	asset, err := issue("euro", "EUR")
+	require.NoError(t, err)
+	require.Equal(t, "EUR", asset.Currency)
+
+	asset, err = issue("default", "")
+	require.NoError(t, err)
+	require.Equal(t, defaultCurrency, asset.Currency)
+
+	_, err = issue("invalid", "XYZ")
+	require.EqualError(t, err, `currency "XYZ" is not supported`)
+	_, err = issue("lowercase", "eur")
+	require.EqualError(t, err, `currency "eur" is not supported`)
+}
+
+func TestTxDate(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("loan1", TRADING))
+
+	date, err := txDate(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, 20210601, date)
+
+	// the date is taken in UTC, whatever the zone of the client that created the timestamp
+	ledger.stub.now = time.Date(2021, time.December, 30, 22, 0, 0, 0, time.FixedZone("UTC-5", -5*60*60))
+	date, err = txDate(ledger.tx(testOutsider))
+	require.NoError(t, err)
+	require.Equal(t, 20211231, date)
+
+	// redeeming without a date uses the transaction date, which has reached maturity
+	require.NoError(t, ledger.contract.RedeemAsset(ledger.tx(testLender), "loan1", 0, ""))
+	require.Equal(t, REDEEMED, ledger.getTestAsset("loan1").State)
+}
+
+func TestCancelAsset(t *testing.T) {
+	require.Equal(t, "CANCELLED", CANCELLED.String())
+	cancelled, err := parseState("CANCELLED")
+	require.NoError(t, err)
+	require.Equal(t, CANCELLED, cancelled)
+
+	ledger := newTestLedger(t)
+	ledger.putTestAsset(newTestAsset("issued", ISSUED))
+	ledger.putTestAsset(newTestAsset("pending", PENDING))
+	ledger.putTestAsset(newTestAsset("trading", TRADING))
+	ledger.putTestAsset(newTestAsset("redeemed", REDEEMED))
+
+	err = ledger.contract.CancelAsset(ledger.tx(testLender), "issued", "")
+	require.EqualError(t, err, "reason must be a non-empty string")
+	err = ledger.contract.CancelAsset(ledger.tx(testOutsider), "issued", "never funded")
+	require.EqualError(t, err, "submitting client is not the lender of asset issued")
+	err = ledger.contract.CancelAsset(ledger.tx(testLender), "trading", "never funded")
+	require.EqualError(t, err, "asset trading is TRADING, only ISSUED or PENDING assets can be cancelled")
+	err = ledger.contract.CancelAsset(ledger.tx(testLender), "redeemed", "never funded")
+	require.EqualError(t, err, "asset redeemed is REDEEMED, only ISSUED or PENDING assets can be cancelled")
+
+	for _, assetID := range []string{"issued", "pending"} {
+		require.NoError(t, ledger.contract.CancelAsset(ledger.tx(testLender), assetID, "never funded"))
+		requireAssetEvent(t, ledger, "AssetCancelled", assetID, CANCELLED)
+
+		asset := ledger.getTestAsset(assetID)
+		require.Equal(t, CANCELLED, asset.State)
+		require.Equal(t, "never funded", asset.CancelReason)
+	}
+
+	// cancelled loans stay queryable
+	assets, err := ledger.contract.QueryAssetsByState(ledger.tx(testOutsider), "CANCELLED")
+	require.NoError(t, err)
+	require.Equal(t, []string{"issued", "pending"}, testAssetIDs(assets))
+}
@@ -0,0 +1,565 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// testMSPID is the organization of the test peer and of the test clients
+const testMSPID = "Org1MSP"
+
+func init() {
+	// verifyClientOrgMatchesPeerOrg reads the organization of the peer from its environment
+	os.Setenv("CORE_PEER_LOCALMSPID", testMSPID)
+}
+
+// testStart is the timestamp of the first transaction on a test ledger
+var testStart = time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// testStub extends the shimtest mock stub with the parts of the stub API it leaves unimplemented:
+// transient data, private data deletes, key history, chaincode events, paginated queries and CouchDB
+// selector queries.
+type testStub struct {
+	*shimtest.MockStub
+	now       time.Time
+	txCount   int
+	transient map[string][]byte
+	history   map[string][]*queryresult.KeyModification
+	event     *peer.ChaincodeEvent
+	stateErr  error
+}
+
+func newTestStub() *testStub {
+	return &testStub{
+		MockStub: shimtest.NewMockStub("cc-asset-loan", nil),
+		now:      testStart,
+		history:  make(map[string][]*queryresult.KeyModification),
+	}
+}
+
+// startTx begins a new transaction at the current time of the stub.
+func (stub *testStub) startTx() {
+	stub.txCount++
+	stub.MockTransactionStart(fmt.Sprintf("tx%d", stub.txCount))
+	stub.TxTimestamp, _ = ptypes.TimestampProto(stub.now)
+	stub.transient = nil
+	stub.event = nil
+}
+
+func (stub *testStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+func (stub *testStub) SetEvent(name string, payload []byte) error {
+	stub.event = &peer.ChaincodeEvent{EventName: name, Payload: payload}
+	return nil
+}
+
+// GetState fails with stateErr when it is set, like a peer that cannot read its state database.
+func (stub *testStub) GetState(key string) ([]byte, error) {
+	if stub.stateErr != nil {
+		return nil, stub.stateErr
+	}
+
+	return stub.MockStub.GetState(key)
+}
+
+func (stub *testStub) PutState(key string, value []byte) error {
+	err := stub.MockStub.PutState(key, value)
+	if err != nil {
+		return err
+	}
+
+	stub.recordHistory(key, value, false)
+	return nil
+}
+
+func (stub *testStub) DelState(key string) error {
+	err := stub.MockStub.DelState(key)
+	if err != nil {
+		return err
+	}
+
+	// a peer drops the key-level endorsement policy together with the key
+	delete(stub.EndorsementPolicies[""], key)
+
+	stub.recordHistory(key, nil, true)
+	return nil
+}
+
+func (stub *testStub) DelPrivateData(collection string, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+// recordHistory keeps the last write of every transaction to a key, like the history database of a peer.
+func (stub *testStub) recordHistory(key string, value []byte, isDelete bool) {
+	timestamp, _ := ptypes.TimestampProto(stub.now)
+	modification := &queryresult.KeyModification{
+		TxId:      stub.TxID,
+		Value:     value,
+		Timestamp: timestamp,
+		IsDelete:  isDelete,
+	}
+
+	modifications := stub.history[key]
+	if len(modifications) > 0 && modifications[len(modifications)-1].TxId == stub.TxID {
+		modifications[len(modifications)-1] = modification
+		return
+	}
+	stub.history[key] = append(modifications, modification)
+}
+
+func (stub *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: stub.history[key]}, nil
+}
+
+func (stub *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	resultsIterator, err := stub.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	var kvs []*queryresult.KV
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+
+	return paginate(kvs, pageSize, bookmark)
+}
+
+// GetQueryResult evaluates the selector of a CouchDB query against the JSON documents in the world state.
+// Equality, $and and the comparison operators used by the chaincode are supported.
+func (stub *testStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	kvs, err := stub.selectorQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stateIterator{kvs: kvs}, nil
+}
+
+func (stub *testStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	kvs, err := stub.selectorQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return paginate(kvs, pageSize, bookmark)
+}
+
+func (stub *testStub) selectorQuery(query string) ([]*queryresult.KV, error) {
+	var parsed struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	err := json.Unmarshal([]byte(query), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %v", query, err)
+	}
+	if parsed.Selector == nil {
+		return nil, fmt.Errorf("query %q has no selector", query)
+	}
+
+	var kvs []*queryresult.KV
+	for elem := stub.Keys.Front(); elem != nil; elem = elem.Next() {
+		key := elem.Value.(string)
+		value := stub.State[key]
+
+		var document map[string]interface{}
+		if json.Unmarshal(value, &document) != nil {
+			continue
+		}
+		if matchesSelector(parsed.Selector, document) {
+			kvs = append(kvs, &queryresult.KV{Key: key, Value: value})
+		}
+	}
+
+	return kvs, nil
+}
+
+// matchesSelector reports whether a JSON document satisfies a CouchDB selector.
+func matchesSelector(selector map[string]interface{}, document map[string]interface{}) bool {
+	for field, condition := range selector {
+		if field == "$and" {
+			for _, clause := range condition.([]interface{}) {
+				if !matchesSelector(clause.(map[string]interface{}), document) {
+					return false
+				}
+			}
+			continue
+		}
+
+		value, exists := document[field]
+		operators, ok := condition.(map[string]interface{})
+		if !ok {
+			if !exists || !reflect.DeepEqual(value, condition) {
+				return false
+			}
+			continue
+		}
+
+		for operator, operand := range operators {
+			if !matchesOperator(operator, value, exists, operand) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func matchesOperator(operator string, value interface{}, exists bool, operand interface{}) bool {
+	switch operator {
+	case "$exists":
+		return exists == operand.(bool)
+	case "$eq":
+		return exists && reflect.DeepEqual(value, operand)
+	case "$ne":
+		return !exists || !reflect.DeepEqual(value, operand)
+	case "$in":
+		for _, candidate := range operand.([]interface{}) {
+			if exists && reflect.DeepEqual(value, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+
+	number, ok := value.(float64)
+	if !exists || !ok {
+		return false
+	}
+	bound := operand.(float64)
+	switch operator {
+	case "$gt":
+		return number > bound
+	case "$gte":
+		return number >= bound
+	case "$lt":
+		return number < bound
+	case "$lte":
+		return number <= bound
+	}
+
+	panic(fmt.Sprintf("unsupported selector operator %v", operator))
+}
+
+// paginate returns the page of pageSize results starting at the key given as bookmark. The bookmark
+// of the result is the key of the first result on the next page.
+func paginate(kvs []*queryresult.KV, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	start := sort.Search(len(kvs), func(i int) bool {
+		return kvs[i].Key >= bookmark
+	})
+
+	end := start + int(pageSize)
+	nextBookmark := ""
+	if end < len(kvs) {
+		nextBookmark = kvs[end].Key
+	} else {
+		end = len(kvs)
+	}
+
+	metadata := &peer.QueryResponseMetadata{
+		FetchedRecordsCount: int32(end - start),
+		Bookmark:            nextBookmark,
+	}
+
+	return &stateIterator{kvs: kvs[start:end]}, metadata, nil
+}
+
+// stateIterator iterates over a fixed list of query results
+type stateIterator struct {
+	kvs  []*queryresult.KV
+	next int
+}
+
+func (iterator *stateIterator) HasNext() bool {
+	return iterator.next < len(iterator.kvs)
+}
+
+func (iterator *stateIterator) Next() (*queryresult.KV, error) {
+	if !iterator.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	iterator.next++
+	return iterator.kvs[iterator.next-1], nil
+}
+
+func (iterator *stateIterator) Close() error {
+	return nil
+}
+
+// historyIterator iterates over the recorded modifications of a key
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+	next          int
+}
+
+func (iterator *historyIterator) HasNext() bool {
+	return iterator.next < len(iterator.modifications)
+}
+
+func (iterator *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !iterator.HasNext() {
+		return nil, fmt.Errorf("no more history")
+	}
+	iterator.next++
+	return iterator.modifications[iterator.next-1], nil
+}
+
+func (iterator *historyIterator) Close() error {
+	return nil
+}
+
+// testIdentity is a client identity with a fixed X.509 identity, organization and role attribute
+type testIdentity struct {
+	name  string
+	mspID string
+	role  string
+	// encodedID replaces the base64 encoded ID returned by GetID when set
+	encodedID string
+}
+
+var (
+	testLender     = &testIdentity{name: "lender", mspID: testMSPID}
+	testBorrower   = &testIdentity{name: "borrower", mspID: testMSPID}
+	testInvestor   = &testIdentity{name: "investor", mspID: testMSPID}
+	testOutsider   = &testIdentity{name: "outsider", mspID: testMSPID}
+	testAdmin      = &testIdentity{name: "admin", mspID: testMSPID, role: roleAdmin}
+	testRater      = &testIdentity{name: "rater", mspID: testMSPID, role: roleRater}
+	testCompliance = &testIdentity{name: "compliance", mspID: testMSPID, role: roleCompliance}
+)
+
+// id returns the decoded identity of the client as stored on assets
+func (identity *testIdentity) id() string {
+	return "x509::CN=" + identity.name + ",OU=client::CN=ca.org1.example.com,O=org1.example.com"
+}
+
+func (identity *testIdentity) GetID() (string, error) {
+	if len(identity.encodedID) != 0 {
+		return identity.encodedID, nil
+	}
+	return base64.StdEncoding.EncodeToString([]byte(identity.id())), nil
+}
+
+func (identity *testIdentity) GetMSPID() (string, error) {
+	return identity.mspID, nil
+}
+
+func (identity *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	if attrName == roleAttribute && len(identity.role) != 0 {
+		return identity.role, true, nil
+	}
+	return "", false, nil
+}
+
+func (identity *testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	value, found, _ := identity.GetAttributeValue(attrName)
+	if !found {
+		return fmt.Errorf("attribute '%s' was not found", attrName)
+	}
+	if value != attrValue {
+		return fmt.Errorf("attribute '%s' equals '%s', not '%s'", attrName, value, attrValue)
+	}
+	return nil
+}
+
+func (identity *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return nil, nil
+}
+
+// testLedger is a world state the tests submit transactions against
+type testLedger struct {
+	t        *testing.T
+	stub     *testStub
+	contract *SmartContract
+}
+
+func newTestLedger(t *testing.T) *testLedger {
+	return &testLedger{t: t, stub: newTestStub(), contract: &SmartContract{}}
+}
+
+// tx starts a new transaction submitted by identity and returns its context.
+func (ledger *testLedger) tx(identity *testIdentity) contractapi.TransactionContextInterface {
+	ledger.stub.startTx()
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(ledger.stub)
+	ctx.SetClientIdentity(identity)
+	return ctx
+}
+
+// txWithTransient starts a new transaction like tx, passing the values JSON encoded in the transient map.
+func (ledger *testLedger) txWithTransient(identity *testIdentity, transient map[string]interface{}) contractapi.TransactionContextInterface {
+	ctx := ledger.tx(identity)
+
+	ledger.stub.transient = make(map[string][]byte)
+	for key, value := range transient {
+		valueJSON, err := json.Marshal(value)
+		require.NoError(ledger.t, err)
+		ledger.stub.transient[key] = valueJSON
+	}
+	return ctx
+}
+
+// advance moves the clock of the ledger forward.
+func (ledger *testLedger) advance(duration time.Duration) {
+	ledger.stub.now = ledger.stub.now.Add(duration)
+}
+
+// newTestAsset returns an asset of testLender in the given state. Assets past ISSUED have testBorrower as borrower.
+func newTestAsset(assetID string, state State) Asset {
+	asset := Asset{
+		Type:      loanAssetType,
+		ID:        assetID,
+		Owner:     testLender.id(),
+		Lender:    testLender.id(),
+		State:     state,
+		Amount:    1000,
+		StartDate: 20210101,
+		EndDate:   20211231,
+		IssuerMSP: testMSPID,
+		Currency:  defaultCurrency,
+	}
+	if state != ISSUED {
+		asset.Borrower = testBorrower.id()
+	}
+	return asset
+}
+
+// putTestAsset writes an asset to the world state as it is, as if it had been stored by an earlier transaction.
+func (ledger *testLedger) putTestAsset(asset Asset) {
+	ledger.stub.startTx()
+
+	compositeKey, err := ledger.stub.CreateCompositeKey(typeAsset, []string{asset.ID})
+	require.NoError(ledger.t, err)
+	assetJSON, err := json.Marshal(asset)
+	require.NoError(ledger.t, err)
+	require.NoError(ledger.t, ledger.stub.PutState(compositeKey, assetJSON))
+}
+
+// putTestConfig writes a setting to the world state, as if it had been set by an admin.
+func (ledger *testLedger) putTestConfig(name string, value interface{}) {
+	ledger.stub.startTx()
+
+	configKey, err := ledger.stub.CreateCompositeKey(configObjectType, []string{name})
+	require.NoError(ledger.t, err)
+	configJSON, err := json.Marshal(value)
+	require.NoError(ledger.t, err)
+	require.NoError(ledger.t, ledger.stub.PutState(configKey, configJSON))
+}
+
+// getTestAsset reads an asset from the world state without validating it, failing the test if it does not exist.
+func (ledger *testLedger) getTestAsset(assetID string) *Asset {
+	compositeKey, err := ledger.stub.CreateCompositeKey(typeAsset, []string{assetID})
+	require.NoError(ledger.t, err)
+
+	assetJSON := ledger.stub.State[compositeKey]
+	require.NotNil(ledger.t, assetJSON, "asset %v does not exist", assetID)
+
+	var asset Asset
+	require.NoError(ledger.t, json.Unmarshal(assetJSON, &asset))
+	return &asset
+}
+
+// endorsingOrgs returns the organizations of the state-based endorsement policy of an asset.
+func (ledger *testLedger) endorsingOrgs(assetID string) []string {
+	compositeKey, err := ledger.stub.CreateCompositeKey(typeAsset, []string{assetID})
+	require.NoError(ledger.t, err)
+
+	policy, err := ledger.stub.GetStateValidationParameter(compositeKey)
+	require.NoError(ledger.t, err)
+	require.NotNil(ledger.t, policy, "asset %v has no endorsement policy", assetID)
+
+	endorsementPolicy, err := statebased.NewStateEP(policy)
+	require.NoError(ledger.t, err)
+	return endorsementPolicy.ListOrgs()
+}
+
+// testAssetIDs returns the IDs of the assets in order.
+func testAssetIDs(assets []*Asset) []string {
+	ids := []string{}
+	for _, asset := range assets {
+		ids = append(ids, asset.ID)
+	}
+	return ids
+}
+
+// Private scalars of the fixed P-256 keys the tests sign with
+const (
+	testKeyScalar      = "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"
+	testOtherKeyScalar = "0f56db78ca460b055c500064824bed999a25aaf48ebb519ac201537b85479813"
+)
+
+// testSigningKey returns the fixed P-256 key with the given hex encoded private scalar.
+func testSigningKey(t *testing.T, scalarHex string) *ecdsa.PrivateKey {
+	return testCurveKey(t, elliptic.P256(), scalarHex)
+}
+
+// testCurveKey returns the fixed key on curve with the given hex encoded private scalar.
+func testCurveKey(t *testing.T, curve elliptic.Curve, scalarHex string) *ecdsa.PrivateKey {
+	scalar, ok := new(big.Int).SetString(scalarHex, 16)
+	require.True(t, ok)
+
+	key := &ecdsa.PrivateKey{D: scalar}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = key.PublicKey.Curve.ScalarBaseMult(scalar.Bytes())
+	return key
+}
+
+// testPublicKeyHex returns the hex encoded PKIX public key of key.
+func testPublicKeyHex(t *testing.T, key *ecdsa.PrivateKey) string {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return hex.EncodeToString(pubKeyBytes)
+}
+
+// testSign returns the hex encoded ASN.1 ECDSA signature of key over digest.
+func testSign(t *testing.T, key *ecdsa.PrivateKey, digest []byte) string {
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest)
+	require.NoError(t, err)
+	return hex.EncodeToString(signature)
+}
+
+// testRegion is the region of the assets issued by issueTestAsset
+const testRegion = "EU"
+
+// issueTestAsset issues an asset with testLender as lender through IssueAsset.
+func (ledger *testLedger) issueTestAsset(assetID string, amount int, start int, end int) (*Asset, error) {
+	ctx := ledger.txWithTransient(testLender, map[string]interface{}{
+		assetID: AssetPrivate{SecretMessage: "terms of " + assetID},
+	})
+	return ledger.contract.IssueAsset(ctx, assetID, amount, start, end, testRegion, "")
+}
@@ -16,6 +16,9 @@ func main() {
 		log.Panicf("Error creating chaincode: %v", err)
 	}
 
+	// IssueAsset returns the created asset since 2.0.0 and takes the loan currency since 3.0.0
+	cc.Info.Version = "3.0.0"
+
 	if err := cc.Start(); err != nil {
 		log.Panicf("Error starting chaincode: %v", err)
 	}